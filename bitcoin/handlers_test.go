@@ -0,0 +1,212 @@
+package bitcoin
+
+import (
+	"crypto-api/fakenode"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestServer serves the handlers of the coin against a fresh fake node.
+func newTestServer(t *testing.T, config CoinConfig) (*fakenode.Node, *httptest.Server) {
+	t.Helper()
+	return fakenode.NewAPIServer(t, fakenode.Bitcoin, func(router *mux.Router, node *fakenode.Node) {
+		config.RPCIP = node.Host()
+		config.RPCPort = node.Port()
+		config.RPCUser = node.User
+		config.RPCPassword = node.Password
+		RegisterHandlers(router, config)
+	})
+}
+
+func TestLabels(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+
+	status, response := fakenode.Post(t, server, "/getnewaddress", "", map[string]interface{}{"params": []string{"customers", "bech32"}})
+	var address string
+	if rpcErr := fakenode.DecodeResponse(t, response, &address); status != http.StatusOK || rpcErr != nil {
+		t.Fatalf("getnewaddress: %d %s", status, response)
+	}
+
+	status, response = fakenode.Post(t, server, "/setlabel", "", map[string]string{"address": address, "label": "vip"})
+	if status != http.StatusOK {
+		t.Fatalf("setlabel: %d %s", status, response)
+	}
+	if label, _ := node.Wallet("").Label(address); label != "vip" {
+		t.Errorf("label is %q, want vip", label)
+	}
+
+	_, response = fakenode.Post(t, server, "/getaddressesbylabel", "", map[string]string{"label": "vip"})
+	var addresses map[string]json.RawMessage
+	if rpcErr := fakenode.DecodeResponse(t, response, &addresses); rpcErr != nil {
+		t.Fatalf("getaddressesbylabel: %v", rpcErr)
+	}
+	if _, ok := addresses[address]; !ok {
+		t.Errorf("getaddressesbylabel returned %s, want %s", response, address)
+	}
+
+	_, response = fakenode.Post(t, server, "/listlabels", "", map[string]string{})
+	var labels []string
+	if rpcErr := fakenode.DecodeResponse(t, response, &labels); rpcErr != nil {
+		t.Fatalf("listlabels: %v", rpcErr)
+	}
+	if len(labels) != 1 || labels[0] != "vip" {
+		t.Errorf("listlabels returned %v, want [vip]", labels)
+	}
+}
+
+func TestSetLabelNodeError(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+	address := node.Wallet("").NewAddress("")
+	node.FailNext("setlabel", &fakenode.RPCError{Code: fakenode.ErrWalletInvalidLabel, Message: "Invalid label name"})
+
+	status, response := fakenode.Post(t, server, "/setlabel", "", map[string]string{"address": address, "label": "vip"})
+	if status != http.StatusBadGateway {
+		t.Errorf("status %d %s, want %d", status, response, http.StatusBadGateway)
+	}
+	if label, _ := node.Wallet("").Label(address); label != "" {
+		t.Errorf("label changed to %q", label)
+	}
+}
+
+func TestSendTransactions(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+	wallet := node.Wallet("")
+	wallet.Receive(wallet.NewAddress(""), 1, 6)
+
+	status, response := fakenode.Post(t, server, "/sendtransactions", fakenode.APIKey, map[string]interface{}{"address": "bcrt1qdestination", "amount": 0.5})
+	var txid string
+	if rpcErr := fakenode.DecodeResponse(t, response, &txid); status != http.StatusOK || rpcErr != nil {
+		t.Fatalf("sendtransactions: %d %s", status, response)
+	}
+
+	var sent bool
+	for _, tx := range wallet.Transactions() {
+		if tx.TxID == txid && tx.Category == "send" && tx.Address == "bcrt1qdestination" && tx.Amount == -0.5 {
+			sent = true
+		}
+	}
+	if !sent {
+		t.Errorf("no send of 0.5 to bcrt1qdestination in %+v", wallet.Transactions())
+	}
+}
+
+func TestSendTransactionsWallet(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{Wallet: "hot"})
+	hot := node.AddWallet("hot", false)
+	hot.Receive(hot.NewAddress(""), 1, 6)
+
+	status, response := fakenode.Post(t, server, "/sendtransactions", fakenode.APIKey, map[string]interface{}{"address": "bcrt1qdestination", "amount": 0.5, "comment": "payout"})
+	if rpcErr := fakenode.DecodeResponse(t, response, nil); status != http.StatusOK || rpcErr != nil {
+		t.Fatalf("sendtransactions: %d %s", status, response)
+	}
+	for _, call := range node.Calls() {
+		if call.Method == "sendtoaddress" && call.Wallet != "hot" {
+			t.Errorf("sendtoaddress reached wallet %q, want hot", call.Wallet)
+		}
+	}
+}
+
+func TestSendTransactionsParams(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+	wallet := node.Wallet("")
+	wallet.Receive(wallet.NewAddress(""), 1, 6)
+
+	status, response := fakenode.Post(t, server, "/sendtransactions", fakenode.APIKey, map[string]interface{}{"address": "bcrt1qdestination", "amount": 0.5, "comment_to": "alice", "conf_target": 6})
+	if rpcErr := fakenode.DecodeResponse(t, response, nil); status != http.StatusOK || rpcErr != nil {
+		t.Fatalf("sendtransactions: %d %s", status, response)
+	}
+
+	// Options left out must not shift the ones given into their place.
+	want := []string{`"bcrt1qdestination"`, `0.5`, `""`, `"alice"`, `false`, `null`, `6`, `null`}
+	for _, call := range node.Calls() {
+		if call.Method != "sendtoaddress" {
+			continue
+		}
+		if len(call.Params) != len(want) {
+			t.Fatalf("sendtoaddress params %s, want %v", call.Params, want)
+		}
+		for i, param := range call.Params {
+			if string(param) != want[i] {
+				t.Errorf("sendtoaddress param %d is %s, want %s", i, param, want[i])
+			}
+		}
+	}
+}
+
+func TestSendTransactionsNodeError(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+	node.FailNext("sendtoaddress", &fakenode.RPCError{Code: fakenode.ErrInsufficientFunds, Message: "Insufficient funds"})
+
+	_, response := fakenode.Post(t, server, "/sendtransactions", fakenode.APIKey, map[string]interface{}{"address": "bcrt1qdestination", "amount": 0.5})
+	rpcErr := fakenode.DecodeResponse(t, response, nil)
+	if rpcErr == nil || rpcErr.Code != fakenode.ErrInsufficientFunds {
+		t.Errorf("got %s, want error %d", response, fakenode.ErrInsufficientFunds)
+	}
+}
+
+func TestSendTransactionsRequiresAPIKey(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+
+	for _, key := range []string{"", "unknown-key"} {
+		status, _ := fakenode.Post(t, server, "/sendtransactions", key, map[string]interface{}{"address": "bcrt1qdestination", "amount": 0.5})
+		if status != http.StatusUnauthorized {
+			t.Errorf("key %q: status %d, want %d", key, status, http.StatusUnauthorized)
+		}
+	}
+	if node.Called("sendtoaddress") {
+		t.Error("sendtoaddress reached the node")
+	}
+}
+
+func TestSendTransactionsPolicy(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{Policy: SpendingPolicy{
+		MinAmount:        0.001,
+		MaxAmount:        1,
+		BlockedAddresses: []string{"bcrt1qblocked"},
+	}})
+
+	tests := []struct {
+		address string
+		amount  float64
+		code    string
+	}{
+		{"bcrt1qdestination", 0.0001, "amount_below_minimum"},
+		{"bcrt1qdestination", 2, "amount_above_maximum"},
+		{"bcrt1qblocked", 0.5, "destination_blocked"},
+	}
+	for _, test := range tests {
+		status, response := fakenode.Post(t, server, "/sendtransactions", fakenode.APIKey, map[string]interface{}{"address": test.address, "amount": test.amount})
+		var rejection struct {
+			Violations []PolicyViolation `json:"violations"`
+		}
+		json.Unmarshal(response, &rejection)
+		if status != http.StatusUnprocessableEntity || len(rejection.Violations) != 1 || rejection.Violations[0].Code != test.code {
+			t.Errorf("send %v to %s: %d %s, want %s", test.amount, test.address, status, response, test.code)
+		}
+	}
+	if node.Called("sendtoaddress") {
+		t.Error("sendtoaddress reached the node")
+	}
+}
+
+func TestRawRPCRefusesSpendingMethods(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{RPCAllowlist: []string{"getblockcount", "sendtoaddress", "sendrawtransaction", "bumpfee"}})
+
+	status, response := fakenode.Post(t, server, "/rpc", fakenode.APIKey, map[string]interface{}{"method": "getblockcount"})
+	if rpcErr := fakenode.DecodeResponse(t, response, nil); status != http.StatusOK || rpcErr != nil {
+		t.Errorf("getblockcount: %d %s", status, response)
+	}
+
+	for _, method := range []string{"sendtoaddress", "sendrawtransaction", "bumpfee", "dumpprivkey"} {
+		status, _ := fakenode.Post(t, server, "/rpc", fakenode.APIKey, map[string]interface{}{"method": method, "params": []string{"00"}})
+		if status != http.StatusForbidden {
+			t.Errorf("%s: status %d, want %d", method, status, http.StatusForbidden)
+		}
+		if node.Called(method) {
+			t.Errorf("%s reached the node", method)
+		}
+	}
+}
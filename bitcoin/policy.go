@@ -0,0 +1,196 @@
+package bitcoin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// dustLimit is the smallest amount the network relays to a standard output.
+const dustLimit = 0.00000546
+
+const spendWindow = 24 * time.Hour
+
+// SpendingPolicy limits what sendTransactionsHandler may send. Zero values
+// disable the corresponding limit; the minimum never drops below dustLimit.
+type SpendingPolicy struct {
+	MinAmount        float64  `json:"minamount,omitempty"`
+	MaxAmount        float64  `json:"maxamount,omitempty"`
+	ClientDailyCap   float64  `json:"clientdailycap,omitempty"`
+	GlobalDailyCap   float64  `json:"globaldailycap,omitempty"`
+	BlockedAddresses []string `json:"blockedaddresses,omitempty"`
+}
+
+// PolicyViolation is a machine-readable reason for rejecting a send.
+type PolicyViolation struct {
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+	Limit   float64 `json:"limit,omitempty"`
+}
+
+// spendingMethods move funds, or broadcast or re-broadcast a transaction
+// that does, and must go through sendTransactionsHandler so the policy
+// applies, even when allowlisted for the raw RPC endpoint. Signing alone does
+// not spend; the signed transaction is refused at sendrawtransaction.
+var spendingMethods = map[string]bool{
+	"send":               true,
+	"sendall":            true,
+	"sendfrom":           true,
+	"sendmany":           true,
+	"sendtoaddress":      true,
+	"sendrawtransaction": true,
+	"submitpackage":      true,
+	"bumpfee":            true,
+	"psbtbumpfee":        true,
+	"move":               true, // dogecoin's account API
+}
+
+// reserveSpendScript atomically drops spends older than the window from the
+// client and global sets, and records the new spend unless it would exceed a
+// cap. Members are "<nonce>:<amount>". It returns the amounts already spent
+// by the client and globally, and "1" when the spend was recorded.
+var reserveSpendScript = redis.NewScript(`
+local amount = tonumber(ARGV[3])
+local caps = {tonumber(ARGV[5]), tonumber(ARGV[6])}
+local spent = {0, 0}
+local ok = true
+for i = 1, 2 do
+	redis.call('ZREMRANGEBYSCORE', KEYS[i], '-inf', '(' .. ARGV[2])
+	for _, m in ipairs(redis.call('ZRANGE', KEYS[i], 0, -1)) do
+		spent[i] = spent[i] + tonumber(string.match(m, '([^:]+)$'))
+	end
+	if caps[i] > 0 and spent[i] + amount > caps[i] then
+		ok = false
+	end
+end
+if ok then
+	for i = 1, 2 do
+		redis.call('ZADD', KEYS[i], ARGV[1], ARGV[4])
+		redis.call('PEXPIRE', KEYS[i], ARGV[7])
+	end
+end
+return {tostring(spent[1]), tostring(spent[2]), ok and '1' or '0'}
+`)
+
+func checkSpendingPolicy(policy SpendingPolicy, address string, amount float64) []PolicyViolation {
+	var violations []PolicyViolation
+
+	minAmount := policy.MinAmount
+	if minAmount < dustLimit {
+		minAmount = dustLimit
+	}
+	if amount < minAmount {
+		violations = append(violations, PolicyViolation{
+			Code:    "amount_below_minimum",
+			Message: fmt.Sprintf("Amount must be at least %v", minAmount),
+			Limit:   minAmount,
+		})
+	}
+
+	if policy.MaxAmount > 0 && amount > policy.MaxAmount {
+		violations = append(violations, PolicyViolation{
+			Code:    "amount_above_maximum",
+			Message: fmt.Sprintf("Amount must be at most %v", policy.MaxAmount),
+			Limit:   policy.MaxAmount,
+		})
+	}
+
+	for _, blocked := range policy.BlockedAddresses {
+		if address == blocked {
+			violations = append(violations, PolicyViolation{
+				Code:    "destination_blocked",
+				Message: "Destination address is blocked",
+			})
+			break
+		}
+	}
+
+	return violations
+}
+
+// reserveDailySpend counts amount against the rolling 24h caps of the client
+// and of the coin. The returned reservation must be released if the send
+// fails. With no caps configured nothing is reserved.
+func reserveDailySpend(policy SpendingPolicy, client string, amount float64) (string, []PolicyViolation, error) {
+	if policy.ClientDailyCap <= 0 && policy.GlobalDailyCap <= 0 {
+		return "", nil, nil
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	reservation := hex.EncodeToString(nonce) + ":" + strconv.FormatFloat(amount, 'f', -1, 64)
+
+	now := time.Now()
+	keys := []string{clientSpendKey(client), globalSpendKey()}
+	result, err := reserveSpendScript.Run(context.Background(), redisClient, keys,
+		now.UnixMilli(),
+		now.Add(-spendWindow).UnixMilli(),
+		amount,
+		reservation,
+		policy.ClientDailyCap,
+		policy.GlobalDailyCap,
+		spendWindow.Milliseconds(),
+	).StringSlice()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if result[2] == "1" {
+		return reservation, nil, nil
+	}
+
+	var violations []PolicyViolation
+	clientSpent, _ := strconv.ParseFloat(result[0], 64)
+	globalSpent, _ := strconv.ParseFloat(result[1], 64)
+	if policy.ClientDailyCap > 0 && clientSpent+amount > policy.ClientDailyCap {
+		violations = append(violations, PolicyViolation{
+			Code:    "client_daily_cap_exceeded",
+			Message: fmt.Sprintf("Client has already sent %v of %v in the last 24h", clientSpent, policy.ClientDailyCap),
+			Limit:   policy.ClientDailyCap,
+		})
+	}
+	if policy.GlobalDailyCap > 0 && globalSpent+amount > policy.GlobalDailyCap {
+		violations = append(violations, PolicyViolation{
+			Code:    "global_daily_cap_exceeded",
+			Message: fmt.Sprintf("Wallet has already sent %v of %v in the last 24h", globalSpent, policy.GlobalDailyCap),
+			Limit:   policy.GlobalDailyCap,
+		})
+	}
+	return "", violations, nil
+}
+
+func releaseDailySpend(client, reservation string) error {
+	if reservation == "" {
+		return nil
+	}
+	ctx := context.Background()
+	if err := redisClient.ZRem(ctx, clientSpendKey(client), reservation).Err(); err != nil {
+		return err
+	}
+	return redisClient.ZRem(ctx, globalSpendKey(), reservation).Err()
+}
+
+func clientSpendKey(client string) string {
+	return getPackageName() + "_spend_client_" + client
+}
+
+func globalSpendKey() string {
+	return getPackageName() + "_spend_global"
+}
+
+func writePolicyViolations(w http.ResponseWriter, violations []PolicyViolation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(struct {
+		Error      string            `json:"error"`
+		Violations []PolicyViolation `json:"violations"`
+	}{"spending policy violation", violations})
+}
@@ -0,0 +1,171 @@
+package bitcoin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// descriptorTemplates maps the address_type parameter of getnewaddress to the
+// output descriptor used for keys derived from an xpub.
+var descriptorTemplates = map[string]string{
+	"legacy":      "pkh(%s)",
+	"p2sh-segwit": "sh(wpkh(%s))",
+	"bech32":      "wpkh(%s)",
+	"bech32m":     "tr(%s)",
+}
+
+type importDescriptorRequest struct {
+	Desc      string      `json:"desc"`
+	Active    bool        `json:"active,omitempty"`
+	Range     []int       `json:"range,omitempty"`
+	Timestamp interface{} `json:"timestamp"`
+	Internal  bool        `json:"internal,omitempty"`
+}
+
+// importWatchOnlyHandler imports an xpub or an output descriptor into a
+// descriptor wallet without private keys, so the wallet endpoints work for
+// keys whose private halves never touch the server.
+func importWatchOnlyHandler(config CoinConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Wallet       string      `json:"wallet,omitempty"`
+			XPub         string      `json:"xpub,omitempty"`
+			Descriptor   string      `json:"descriptor,omitempty"`
+			AddressType  string      `json:"address_type,omitempty"`
+			Range        []int       `json:"range,omitempty"`
+			Timestamp    interface{} `json:"timestamp,omitempty"`
+			CreateWallet bool        `json:"create_wallet,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if (req.XPub == "") == (req.Descriptor == "") {
+			http.Error(w, "Exactly one of xpub or descriptor is required", http.StatusBadRequest)
+			return
+		}
+
+		wallet := req.Wallet
+		if wallet == "" {
+			wallet = config.Wallet
+		}
+
+		rangeParam := []int{0, 999}
+		if req.Range != nil {
+			if len(req.Range) != 2 || req.Range[0] < 0 || req.Range[0] > req.Range[1] {
+				http.Error(w, "Range must be [start, end] with 0 <= start <= end", http.StatusBadRequest)
+				return
+			}
+			rangeParam = req.Range
+		}
+
+		// importdescriptors takes either a unix time to rescan from or "now"
+		// to skip the rescan entirely.
+		timestamp := req.Timestamp
+		switch t := timestamp.(type) {
+		case nil:
+			timestamp = "now"
+		case string:
+			if t != "now" {
+				http.Error(w, "Timestamp must be a unix time or \"now\"", http.StatusBadRequest)
+				return
+			}
+		case float64:
+			timestamp = int64(t)
+		default:
+			http.Error(w, "Timestamp must be a unix time or \"now\"", http.StatusBadRequest)
+			return
+		}
+
+		var imports []importDescriptorRequest
+		if req.XPub != "" {
+			addressType := req.AddressType
+			if addressType == "" {
+				addressType = "bech32"
+			}
+			template, ok := descriptorTemplates[addressType]
+			if !ok {
+				http.Error(w, "Unknown address_type", http.StatusBadRequest)
+				return
+			}
+			for _, internal := range []bool{false, true} {
+				branch := 0
+				if internal {
+					branch = 1
+				}
+				imports = append(imports, importDescriptorRequest{
+					Desc:     fmt.Sprintf(template, fmt.Sprintf("%s/%d/*", req.XPub, branch)),
+					Active:   true,
+					Range:    rangeParam,
+					Internal: internal,
+				})
+			}
+		} else {
+			imp := importDescriptorRequest{Desc: req.Descriptor}
+			if strings.Contains(req.Descriptor, "*") {
+				imp.Range = rangeParam
+			}
+			imports = append(imports, imp)
+		}
+
+		// importdescriptors requires the checksum, which getdescriptorinfo
+		// appends to the normalized descriptor.
+		for i := range imports {
+			imports[i].Timestamp = timestamp
+			desc, err := descriptorWithChecksum(config, imports[i].Desc)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			imports[i].Desc = desc
+		}
+
+		if req.CreateWallet {
+			if wallet == "" {
+				http.Error(w, "A wallet name is required to create a wallet", http.StatusBadRequest)
+				return
+			}
+			// wallet_name, disable_private_keys, blank, passphrase, avoid_reuse, descriptors
+			response, err := makeJSONRPCRequest(config, "createwallet", []interface{}{wallet, true, true, "", false, true})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := rpcError(response); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+
+		response, err := makeWalletRPCRequest(config, wallet, "importdescriptors", []interface{}{imports})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+func descriptorWithChecksum(config CoinConfig, descriptor string) (string, error) {
+	response, err := makeJSONRPCRequest(config, "getdescriptorinfo", []interface{}{descriptor})
+	if err != nil {
+		return "", err
+	}
+	if err := rpcError(response); err != nil {
+		return "", err
+	}
+
+	var info struct {
+		Result struct {
+			Descriptor string `json:"descriptor"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(response, &info); err != nil {
+		return "", err
+	}
+	return info.Result.Descriptor, nil
+}
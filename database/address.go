@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Address is an entry of the address book linking a wallet address to one of
+// our customers.
+type Address struct {
+	Coin        string          `json:"coin"`
+	Address     string          `json:"address"`
+	Label       string          `json:"label"`
+	CustomerRef string          `json:"customer_ref"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func (db *DB) CreateAddressBookTable() error {
+	sqlStatement := `
+		CREATE TABLE IF NOT EXISTS address_book (
+			coin         TEXT NOT NULL,
+			address      TEXT NOT NULL,
+			label        TEXT NOT NULL DEFAULT '',
+			customer_ref TEXT NOT NULL DEFAULT '',
+			metadata     JSONB,
+			created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (coin, address)
+		);
+		CREATE INDEX IF NOT EXISTS address_book_customer_ref_idx ON address_book (coin, customer_ref);
+		CREATE INDEX IF NOT EXISTS address_book_label_idx ON address_book (coin, label);`
+	_, err := db.Exec(sqlStatement)
+	return err
+}
+
+// UpsertAddress stores the address book entry, replacing the label of an
+// existing entry for the same coin and address. An empty customer reference
+// or metadata keeps the stored one.
+func (db *DB) UpsertAddress(a *Address) error {
+	var customerRef, metadata interface{}
+	if a.CustomerRef != "" {
+		customerRef = a.CustomerRef
+	}
+	if len(a.Metadata) > 0 {
+		metadata = string(a.Metadata)
+	}
+	sqlStatement := `
+		INSERT INTO address_book (coin, address, label, customer_ref, metadata)
+		VALUES ($1, $2, $3, COALESCE($4, ''), $5::jsonb)
+		ON CONFLICT (coin, address) DO UPDATE
+		SET label=EXCLUDED.label,
+			customer_ref=COALESCE($4, address_book.customer_ref),
+			metadata=COALESCE(EXCLUDED.metadata, address_book.metadata),
+			updated_at=now()
+		RETURNING customer_ref, metadata, created_at, updated_at`
+	var stored sql.NullString
+	err := db.QueryRow(sqlStatement, a.Coin, a.Address, a.Label, customerRef, metadata).Scan(&a.CustomerRef, &stored, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if stored.Valid {
+		a.Metadata = json.RawMessage(stored.String)
+	}
+	return nil
+}
+
+// GetAddress returns sql.ErrNoRows when the address is not in the address book.
+func (db *DB) GetAddress(coin, address string) (*Address, error) {
+	sqlStatement := `
+		SELECT coin, address, label, customer_ref, metadata, created_at, updated_at
+		FROM address_book WHERE coin=$1 AND address=$2;`
+	return scanAddress(db.QueryRow(sqlStatement, coin, address))
+}
+
+// FindAddresses lists the entries of a coin matching the given customer
+// reference and label. Empty filters match everything.
+func (db *DB) FindAddresses(coin, customerRef, label string) ([]*Address, error) {
+	sqlStatement := `
+		SELECT coin, address, label, customer_ref, metadata, created_at, updated_at
+		FROM address_book
+		WHERE coin=$1 AND ($2 = '' OR customer_ref=$2) AND ($3 = '' OR label=$3)
+		ORDER BY created_at;`
+	rows, err := db.Query(sqlStatement, coin, customerRef, label)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []*Address{}
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
+
+func (db *DB) DeleteAddress(coin, address string) error {
+	sqlStatement := `DELETE FROM address_book WHERE coin=$1 AND address=$2;`
+	_, err := db.Exec(sqlStatement, coin, address)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAddress(row rowScanner) (*Address, error) {
+	a := &Address{}
+	var metadata sql.NullString
+	err := row.Scan(&a.Coin, &a.Address, &a.Label, &a.CustomerRef, &metadata, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Valid {
+		a.Metadata = json.RawMessage(metadata.String)
+	}
+	return a, nil
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// RPCAudit records a raw node RPC call made through the API.
+type RPCAudit struct {
+	ID        int             `json:"id"`
+	Coin      string          `json:"coin"`
+	Client    string          `json:"client"`
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	Allowed   bool            `json:"allowed"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// secretMethods take passphrases, private keys or seeds as parameters, which
+// must never reach the audit table.
+var secretMethods = map[string]bool{
+	"createwallet":              true,
+	"encryptwallet":             true,
+	"walletpassphrase":          true,
+	"walletpassphrasechange":    true,
+	"importprivkey":             true,
+	"dumpprivkey":               true,
+	"importdescriptors":         true,
+	"importmulti":               true,
+	"sethdseed":                 true,
+	"signmessagewithprivkey":    true,
+	"signrawtransactionwithkey": true,
+}
+
+var redactedParams = json.RawMessage(`"redacted"`)
+
+func (db *DB) CreateRPCAuditTable() error {
+	sqlStatement := `
+		CREATE TABLE IF NOT EXISTS rpc_audit (
+			id         SERIAL PRIMARY KEY,
+			coin       TEXT NOT NULL,
+			client     TEXT NOT NULL,
+			method     TEXT NOT NULL,
+			params     JSONB,
+			allowed    BOOLEAN NOT NULL,
+			error      TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`
+	_, err := db.Exec(sqlStatement)
+	return err
+}
+
+func (db *DB) CreateRPCAudit(a *RPCAudit) error {
+	if secretMethods[a.Method] && len(a.Params) > 0 {
+		a.Params = redactedParams
+	}
+	var params interface{}
+	if len(a.Params) > 0 {
+		params = string(a.Params)
+	}
+	sqlStatement := `
+		INSERT INTO rpc_audit (coin, client, method, params, allowed, error)
+		VALUES ($1, $2, $3, $4::jsonb, $5, $6)
+		RETURNING id, created_at`
+	return db.QueryRow(sqlStatement, a.Coin, a.Client, a.Method, params, a.Allowed, a.Error).Scan(&a.ID, &a.CreatedAt)
+}
@@ -24,5 +24,11 @@ func New() (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	// sql.Open does not connect, so check the server is reachable here rather
+	// than failing on the first request.
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return &DB{db}, nil
 }
@@ -1,3 +1,5 @@
+package database
+
 /*
 package main
 
@@ -48,4 +50,4 @@ func main() {
 	}
 	fmt.Println("Transaction deleted")
 }
-*/
\ No newline at end of file
+*/
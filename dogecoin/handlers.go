@@ -3,7 +3,10 @@ package dogecoin
 import (
 	"bytes"
 	"context"
+	"crypto-api/database"
+	"crypto-api/helper"
 	"encoding/json"
+	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"io/ioutil"
@@ -13,10 +16,12 @@ import (
 )
 
 type CoinConfig struct {
-	RPCIP       string `json:"rpcip"`
-	RPCPort     string `json:"rpcport"`
-	RPCUser     string `json:"rpcuser"`
-	RPCPassword string `json:"rpcpassword"`
+	RPCIP        string         `json:"rpcip"`
+	RPCPort      string         `json:"rpcport"`
+	RPCUser      string         `json:"rpcuser"`
+	RPCPassword  string         `json:"rpcpassword"`
+	RPCAllowlist []string       `json:"rpcallowlist,omitempty"`
+	Policy       SpendingPolicy `json:"policy"`
 }
 
 type JSONRPCRequest struct {
@@ -28,6 +33,7 @@ type JSONRPCRequest struct {
 
 var (
 	redisClient *redis.Client
+	db          *database.DB
 )
 
 func InitRedis() {
@@ -38,15 +44,22 @@ func InitRedis() {
 	})
 }
 
+func InitDatabase(d *database.DB) {
+	db = d
+}
+
 func RegisterHandlers(router *mux.Router, config CoinConfig) {
 	router.HandleFunc("/tx/{txid}", transactionHandler(config)).Methods("GET")
 	router.HandleFunc("/getnewaddress", getGetNewAddressHandler(config)).Methods("POST")
 	router.HandleFunc("/getbalance", getBalanceHandler(config)).Methods("POST")
 	router.HandleFunc("/getaddressbalance", getAddressBalanceHandler(config)).Methods("POST")
-	router.HandleFunc("/sendtransactions", sendTransactionsHandler(config)).Methods("POST")
+	router.HandleFunc("/sendtransactions", helper.RequireAPIKey(sendTransactionsHandler(config))).Methods("POST")
 	router.HandleFunc("/getreceivedbyaddress", getAddressReceivedHandler(config)).Methods("POST")
 	router.HandleFunc("/listunspent", listUnspentHandler(config)).Methods("POST")
 	router.HandleFunc("/listtransactionsbyaddress", listTransactionsByAddressHandler(config)).Methods("POST")
+	router.HandleFunc("/importwatchonly", helper.RequireAPIKey(importWatchOnlyHandler(config))).Methods("POST")
+	router.HandleFunc("/rpc", helper.RequireAPIKey(rawRPCHandler(config))).Methods("POST")
+	registerLabelHandlers(router, config)
 
 }
 
@@ -335,6 +348,29 @@ func sendTransactionsHandler(config CoinConfig) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+
+		if violations := checkSpendingPolicy(config.Policy, sendReq.Address, sendReq.Amount); len(violations) > 0 {
+			writePolicyViolations(w, violations)
+			return
+		}
+
+		// The daily caps are counted per client, so every spend must be
+		// attributed to one.
+		client := helper.APIClient(r)
+		if client == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		reservation, violations, err := reserveDailySpend(config.Policy, client, sendReq.Amount)
+		if err != nil {
+			http.Error(w, "Error checking spending limits", http.StatusInternalServerError)
+			return
+		}
+		if len(violations) > 0 {
+			writePolicyViolations(w, violations)
+			return
+		}
+
 		params := []interface{}{
 			sendReq.Address,
 			sendReq.Amount,
@@ -353,9 +389,13 @@ func sendTransactionsHandler(config CoinConfig) http.HandlerFunc {
 
 		response, err := makeJSONRPCRequest(config, "sendtoaddress", params)
 		if err != nil {
+			releaseDailySpend(client, reservation)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if rpcError(response) != nil {
+			releaseDailySpend(client, reservation)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(response)
 	}
@@ -388,6 +428,23 @@ func makeJSONRPCRequest(config CoinConfig, method string, params interface{}) ([
 	return responseBytes, nil
 }
 
+// rpcError returns the error reported by the node in a JSON-RPC response.
+func rpcError(response []byte) error {
+	var rpcResponse struct {
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(response, &rpcResponse); err != nil {
+		return err
+	}
+	if rpcResponse.Error != nil {
+		return fmt.Errorf("rpc error %d: %s", rpcResponse.Error.Code, rpcResponse.Error.Message)
+	}
+	return nil
+}
+
 // redis
 func isTransactionProcessed(txid string) (bool, error) {
 	paketadi := getPackageName()
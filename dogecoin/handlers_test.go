@@ -0,0 +1,214 @@
+package dogecoin
+
+import (
+	"crypto-api/fakenode"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestServer serves the handlers of the coin against a fresh fake node.
+func newTestServer(t *testing.T, config CoinConfig) (*fakenode.Node, *httptest.Server) {
+	t.Helper()
+	return fakenode.NewAPIServer(t, fakenode.Dogecoin, func(router *mux.Router, node *fakenode.Node) {
+		config.RPCIP = node.Host()
+		config.RPCPort = node.Port()
+		config.RPCUser = node.User
+		config.RPCPassword = node.Password
+		RegisterHandlers(router, config)
+	})
+}
+
+// Dogecoin Core only has the account API: the label endpoints must reach it
+// through the account RPCs, which the fake node refuses for bitcoin.
+func TestAccounts(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+
+	status, response := fakenode.Post(t, server, "/getnewaddress", "", map[string]interface{}{"params": []string{"customers"}})
+	var address string
+	if rpcErr := fakenode.DecodeResponse(t, response, &address); status != http.StatusOK || rpcErr != nil {
+		t.Fatalf("getnewaddress: %d %s", status, response)
+	}
+	if account, _ := node.Wallet("").Label(address); account != "customers" {
+		t.Errorf("account is %q, want customers", account)
+	}
+
+	status, response = fakenode.Post(t, server, "/setlabel", "", map[string]string{"address": address, "label": "vip"})
+	if status != http.StatusOK {
+		t.Fatalf("setlabel: %d %s", status, response)
+	}
+	if account, _ := node.Wallet("").Label(address); account != "vip" {
+		t.Errorf("account is %q, want vip", account)
+	}
+
+	_, response = fakenode.Post(t, server, "/getaddressesbylabel", "", map[string]string{"label": "vip"})
+	var addresses []string
+	if rpcErr := fakenode.DecodeResponse(t, response, &addresses); rpcErr != nil {
+		t.Fatalf("getaddressesbylabel: %v", rpcErr)
+	}
+	if len(addresses) != 1 || addresses[0] != address {
+		t.Errorf("getaddressesbylabel returned %v, want [%s]", addresses, address)
+	}
+
+	_, response = fakenode.Post(t, server, "/listlabels", "", map[string]string{})
+	var accounts map[string]float64
+	if rpcErr := fakenode.DecodeResponse(t, response, &accounts); rpcErr != nil {
+		t.Fatalf("listlabels: %v", rpcErr)
+	}
+	if _, ok := accounts["vip"]; !ok {
+		t.Errorf("listlabels returned %v, want the vip account", accounts)
+	}
+
+	_, response = fakenode.Post(t, server, "/getaddressinfo", "", map[string]string{"address": address})
+	var info struct {
+		IsMine  bool   `json:"ismine"`
+		Account string `json:"account"`
+	}
+	if rpcErr := fakenode.DecodeResponse(t, response, &info); rpcErr != nil {
+		t.Fatalf("getaddressinfo: %v", rpcErr)
+	}
+	if !info.IsMine || info.Account != "vip" {
+		t.Errorf("getaddressinfo returned %s, want an own address of account vip", response)
+	}
+
+	for _, method := range []string{"listlabels", "getaddressesbylabel", "setlabel", "getaddressinfo"} {
+		if node.Called(method) {
+			t.Errorf("%s reached the node", method)
+		}
+	}
+}
+
+func TestAccountBalance(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+	wallet := node.Wallet("")
+	wallet.Receive(wallet.NewAddress("savings"), 5, 6)
+	wallet.Receive(wallet.NewAddress("spending"), 2, 6)
+
+	_, response := fakenode.Post(t, server, "/getbalance", "", map[string]interface{}{"params": []string{"savings"}})
+	var balance float64
+	if rpcErr := fakenode.DecodeResponse(t, response, &balance); rpcErr != nil {
+		t.Fatalf("getbalance: %v", rpcErr)
+	}
+	if balance != 5 {
+		t.Errorf("balance of savings is %v, want 5", balance)
+	}
+}
+
+func TestSetLabelNodeError(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+
+	// setaccount only accepts addresses of the wallet.
+	status, response := fakenode.Post(t, server, "/setlabel", "", map[string]string{"address": "nforeign", "label": "vip"})
+	if status != http.StatusBadGateway {
+		t.Errorf("status %d %s, want %d", status, response, http.StatusBadGateway)
+	}
+
+	address := node.Wallet("").NewAddress("")
+	node.FailNext("setaccount", &fakenode.RPCError{Code: fakenode.ErrWallet, Message: "Error: wallet is locked"})
+	status, response = fakenode.Post(t, server, "/setlabel", "", map[string]string{"address": address, "label": "vip"})
+	if status != http.StatusBadGateway {
+		t.Errorf("status %d %s, want %d", status, response, http.StatusBadGateway)
+	}
+	if account, _ := node.Wallet("").Label(address); account != "" {
+		t.Errorf("account changed to %q", account)
+	}
+}
+
+func TestSendTransactions(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+	wallet := node.Wallet("")
+	wallet.Receive(wallet.NewAddress(""), 100, 6)
+
+	status, response := fakenode.Post(t, server, "/sendtransactions", fakenode.APIKey, map[string]interface{}{"address": "nfakedestination", "amount": 50, "comment_to": "alice"})
+	var txid string
+	if rpcErr := fakenode.DecodeResponse(t, response, &txid); status != http.StatusOK || rpcErr != nil {
+		t.Fatalf("sendtransactions: %d %s", status, response)
+	}
+
+	var sent bool
+	for _, tx := range wallet.Transactions() {
+		if tx.TxID == txid && tx.Category == "send" && tx.Address == "nfakedestination" && tx.Amount == -50 && tx.To == "alice" {
+			sent = true
+		}
+	}
+	if !sent {
+		t.Errorf("no send of 50 to nfakedestination in %+v", wallet.Transactions())
+	}
+}
+
+func TestSendTransactionsNodeError(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+	node.FailNext("sendtoaddress", &fakenode.RPCError{Code: fakenode.ErrInsufficientFunds, Message: "Insufficient funds"})
+
+	_, response := fakenode.Post(t, server, "/sendtransactions", fakenode.APIKey, map[string]interface{}{"address": "nfakedestination", "amount": 50})
+	rpcErr := fakenode.DecodeResponse(t, response, nil)
+	if rpcErr == nil || rpcErr.Code != fakenode.ErrInsufficientFunds {
+		t.Errorf("got %s, want error %d", response, fakenode.ErrInsufficientFunds)
+	}
+}
+
+func TestSendTransactionsRequiresAPIKey(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{})
+
+	for _, key := range []string{"", "unknown-key"} {
+		status, _ := fakenode.Post(t, server, "/sendtransactions", key, map[string]interface{}{"address": "nfakedestination", "amount": 50})
+		if status != http.StatusUnauthorized {
+			t.Errorf("key %q: status %d, want %d", key, status, http.StatusUnauthorized)
+		}
+	}
+	if node.Called("sendtoaddress") {
+		t.Error("sendtoaddress reached the node")
+	}
+}
+
+func TestSendTransactionsPolicy(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{Policy: SpendingPolicy{
+		MinAmount:        0.001,
+		MaxAmount:        100,
+		BlockedAddresses: []string{"nfakeblocked"},
+	}})
+
+	tests := []struct {
+		address string
+		amount  float64
+		code    string
+	}{
+		{"nfakedestination", 0.005, "amount_below_minimum"}, // below the dust limit
+		{"nfakedestination", 200, "amount_above_maximum"},
+		{"nfakeblocked", 50, "destination_blocked"},
+	}
+	for _, test := range tests {
+		status, response := fakenode.Post(t, server, "/sendtransactions", fakenode.APIKey, map[string]interface{}{"address": test.address, "amount": test.amount})
+		var rejection struct {
+			Violations []PolicyViolation `json:"violations"`
+		}
+		json.Unmarshal(response, &rejection)
+		if status != http.StatusUnprocessableEntity || len(rejection.Violations) != 1 || rejection.Violations[0].Code != test.code {
+			t.Errorf("send %v to %s: %d %s, want %s", test.amount, test.address, status, response, test.code)
+		}
+	}
+	if node.Called("sendtoaddress") {
+		t.Error("sendtoaddress reached the node")
+	}
+}
+
+func TestRawRPCRefusesSpendingMethods(t *testing.T) {
+	node, server := newTestServer(t, CoinConfig{RPCAllowlist: []string{"getblockcount", "sendtoaddress", "sendrawtransaction", "move"}})
+
+	status, response := fakenode.Post(t, server, "/rpc", fakenode.APIKey, map[string]interface{}{"method": "getblockcount"})
+	if rpcErr := fakenode.DecodeResponse(t, response, nil); status != http.StatusOK || rpcErr != nil {
+		t.Errorf("getblockcount: %d %s", status, response)
+	}
+
+	for _, method := range []string{"sendtoaddress", "sendrawtransaction", "move", "dumpprivkey"} {
+		status, _ := fakenode.Post(t, server, "/rpc", fakenode.APIKey, map[string]interface{}{"method": method, "params": []string{"00"}})
+		if status != http.StatusForbidden {
+			t.Errorf("%s: status %d, want %d", method, status, http.StatusForbidden)
+		}
+		if node.Called(method) {
+			t.Errorf("%s reached the node", method)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package dogecoin
+
+import (
+	"crypto-api/helper"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/btcsuite/btcutil"
+	"net/http"
+)
+
+// maxWatchOnlyRange bounds how many keys a single import derives locally.
+const maxWatchOnlyRange = 10000
+
+type importMultiRequest struct {
+	ScriptPubKey string      `json:"scriptPubKey"`
+	PubKeys      []string    `json:"pubkeys"`
+	Timestamp    interface{} `json:"timestamp"`
+	WatchOnly    bool        `json:"watchonly"`
+}
+
+// importWatchOnlyHandler imports the addresses of an xpub as watch-only. Dogecoin
+// Core has neither descriptor wallets nor importdescriptors, so the keys are
+// derived here and handed to importmulti as P2PKH scripts.
+func importWatchOnlyHandler(config CoinConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			XPub       string      `json:"xpub,omitempty"`
+			Descriptor string      `json:"descriptor,omitempty"`
+			Range      []int       `json:"range,omitempty"`
+			Timestamp  interface{} `json:"timestamp,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Descriptor != "" {
+			http.Error(w, "Dogecoin does not support output descriptors, use xpub", http.StatusBadRequest)
+			return
+		}
+
+		if req.XPub == "" {
+			http.Error(w, "xpub is required", http.StatusBadRequest)
+			return
+		}
+
+		rangeParam := []int{0, 999}
+		if req.Range != nil {
+			if len(req.Range) != 2 || req.Range[0] < 0 || req.Range[0] > req.Range[1] {
+				http.Error(w, "Range must be [start, end] with 0 <= start <= end", http.StatusBadRequest)
+				return
+			}
+			rangeParam = req.Range
+		}
+		if rangeParam[1]-rangeParam[0] >= maxWatchOnlyRange {
+			http.Error(w, "Range is too large", http.StatusBadRequest)
+			return
+		}
+
+		// importmulti takes either a unix time to rescan from or "now" to
+		// skip the rescan entirely.
+		timestamp := req.Timestamp
+		switch t := timestamp.(type) {
+		case nil:
+			timestamp = "now"
+		case string:
+			if t != "now" {
+				http.Error(w, "Timestamp must be a unix time or \"now\"", http.StatusBadRequest)
+				return
+			}
+		case float64:
+			timestamp = int64(t)
+		default:
+			http.Error(w, "Timestamp must be a unix time or \"now\"", http.StatusBadRequest)
+			return
+		}
+
+		var imports []importMultiRequest
+		for _, branch := range []uint32{0, 1} {
+			pubKeys, err := helper.DerivePubKeys(req.XPub, branch, uint32(rangeParam[0]), uint32(rangeParam[1]))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, pubKey := range pubKeys {
+				imports = append(imports, importMultiRequest{
+					ScriptPubKey: p2pkhScript(pubKey),
+					PubKeys:      []string{hex.EncodeToString(pubKey)},
+					Timestamp:    timestamp,
+					WatchOnly:    true,
+				})
+			}
+		}
+
+		options := map[string]interface{}{"rescan": timestamp != "now"}
+		response, err := makeJSONRPCRequest(config, "importmulti", []interface{}{imports, options})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+// p2pkhScript returns the hex encoded OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY
+// OP_CHECKSIG script paying to the public key.
+func p2pkhScript(pubKey []byte) string {
+	script := []byte{0x76, 0xa9, 0x14}
+	script = append(script, btcutil.Hash160(pubKey)...)
+	script = append(script, 0x88, 0xac)
+	return hex.EncodeToString(script)
+}
@@ -0,0 +1,139 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Address roles, stored as flags on the address record.
+const (
+	roleSender    = "sender"
+	roleRecipient = "recipient"
+	roleContract  = "contract" // created by a transaction or an internal call
+	roleInternal  = "internal" // touched by an internal call
+)
+
+// recordAddressScript merges a sighting into an address record, keeping the
+// lowest first-seen and highest last-seen block whatever order blocks are
+// scanned in.
+var recordAddressScript = redis.NewScript(`
+local block = tonumber(ARGV[1])
+local first = tonumber(redis.call('HGET', KEYS[1], 'firstseen'))
+if not first or block < first then
+	redis.call('HSET', KEYS[1], 'firstseen', block)
+end
+local last = tonumber(redis.call('HGET', KEYS[1], 'lastseen'))
+if not last or block > last then
+	redis.call('HSET', KEYS[1], 'lastseen', block)
+end
+redis.call('HSET', KEYS[1], 'balance', ARGV[2], 'updatedat', ARGV[3])
+for i = 5, #ARGV do
+	redis.call('HSET', KEYS[1], ARGV[i], 1)
+end
+redis.call('SADD', KEYS[2], ARGV[4])
+return 1
+`)
+
+func registerAddressHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/addresses/{address}", func(w http.ResponseWriter, r *http.Request) {
+		getAddressRecord(w, r, cfg)
+	}).Methods("GET")
+}
+
+// blockAddresses collects the addresses a block touches, with their roles:
+// senders, recipients, created contracts and, when traces are given, the
+// parties of internal calls.
+func blockAddresses(ctx context.Context, client *ethclient.Client, cfg EthereumConfig, block *rpcBlock, traces []txTrace) (map[common.Address]map[string]bool, error) {
+	seen := map[common.Address]map[string]bool{}
+	add := func(address common.Address, role string) {
+		if address == (common.Address{}) {
+			return
+		}
+		if seen[address] == nil {
+			seen[address] = map[string]bool{}
+		}
+		seen[address][role] = true
+	}
+
+	for _, tx := range block.transactions {
+		add(tx.From, roleSender)
+
+		if tx.To != nil {
+			add(*tx.To, roleRecipient)
+			continue
+		}
+		callCtx, cancel := callContext(ctx, cfg)
+		receipt, err := client.TransactionReceipt(callCtx, tx.Hash)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if receipt.Status == types.ReceiptStatusSuccessful {
+			add(receipt.ContractAddress, roleContract)
+		}
+	}
+
+	for _, trace := range traces {
+		walkCalls(trace.Result, func(call callFrame) {
+			add(call.From, roleInternal)
+			if call.Type == "CREATE" || call.Type == "CREATE2" {
+				add(call.To, roleContract)
+			} else {
+				add(call.To, roleInternal)
+			}
+		})
+	}
+
+	return seen, nil
+}
+
+// recordAddress stores a sighting of an address at a block with its current
+// balance, in wei.
+func recordAddress(cfg EthereumConfig, address common.Address, block uint64, balance *big.Int, roles map[string]bool) error {
+	args := []interface{}{block, balance.String(), time.Now().Unix(), address.Hex()}
+	for role := range roles {
+		args = append(args, role)
+	}
+	keys := []string{cfg.redisKey("address", address.Hex()), cfg.redisKey("addresses")}
+	return recordAddressScript.Run(ctx, rdb, keys, args...).Err()
+}
+
+func getAddressRecord(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	address := mux.Vars(r)["address"]
+	if !common.IsHexAddress(address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+	address = common.HexToAddress(address).Hex()
+
+	fields, err := rdb.HGetAll(ctx, cfg.redisKey("address", address)).Result()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(fields) == 0 {
+		http.Error(w, "Address has not been seen by a scan", http.StatusNotFound)
+		return
+	}
+
+	record := AddressRecord{Address: address, Balance: fields["balance"], Roles: []string{}}
+	record.FirstSeen, _ = strconv.ParseUint(fields["firstseen"], 10, 64)
+	record.LastSeen, _ = strconv.ParseUint(fields["lastseen"], 10, 64)
+	record.UpdatedAt, _ = strconv.ParseInt(fields["updatedat"], 10, 64)
+	for _, role := range []string{roleSender, roleRecipient, roleContract, roleInternal} {
+		if fields[role] != "" {
+			record.Roles = append(record.Roles, role)
+		}
+	}
+
+	json.NewEncoder(w).Encode(record)
+}
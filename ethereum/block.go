@@ -0,0 +1,315 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+)
+
+// rpcBlock is a block as the node returns it from eth_getBlockBy*. Header
+// fields that the go-ethereum version in use does not know yet (blob gas,
+// beacon root) are read from the JSON directly, and so is the hash:
+// recomputing it from a header missing fields would give a wrong one.
+type rpcBlock struct {
+	header       *types.Header
+	transactions []*rpcTransaction // when fetched with full transactions
+	txHashes     []common.Hash     // otherwise
+
+	BlockHash             common.Hash         `json:"hash"`
+	Size                  hexutil.Uint64      `json:"size"`
+	TotalDifficulty       *hexutil.Big        `json:"totalDifficulty"`
+	BlobGasUsed           *hexutil.Uint64     `json:"blobGasUsed"`
+	ExcessBlobGas         *hexutil.Uint64     `json:"excessBlobGas"`
+	ParentBeaconBlockRoot *common.Hash        `json:"parentBeaconBlockRoot"`
+	Withdrawals           []*types.Withdrawal `json:"withdrawals"`
+	Transactions          []json.RawMessage   `json:"transactions"`
+}
+
+func (b *rpcBlock) NumberU64() uint64       { return b.header.Number.Uint64() }
+func (b *rpcBlock) Hash() common.Hash       { return b.BlockHash }
+func (b *rpcBlock) ParentHash() common.Hash { return b.header.ParentHash }
+
+// rpcTransaction is a transaction as the node returns it, with its sender.
+// Reading the fields, instead of decoding a types.Transaction, keeps
+// transaction types newer than the go-ethereum version in use readable.
+type rpcTransaction struct {
+	Hash                 common.Hash       `json:"hash"`
+	Type                 hexutil.Uint64    `json:"type"`
+	From                 common.Address    `json:"from"`
+	To                   *common.Address   `json:"to"`
+	Value                *hexutil.Big      `json:"value"`
+	Nonce                hexutil.Uint64    `json:"nonce"`
+	Gas                  hexutil.Uint64    `json:"gas"`
+	GasPrice             *hexutil.Big      `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big      `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big      `json:"maxPriorityFeePerGas"`
+	MaxFeePerBlobGas     *hexutil.Big      `json:"maxFeePerBlobGas"`
+	Input                hexutil.Bytes     `json:"input"`
+	AccessList           *types.AccessList `json:"accessList"`
+	BlobVersionedHashes  []common.Hash     `json:"blobVersionedHashes"`
+	BlockHash            *common.Hash      `json:"blockHash"`
+	BlockNumber          *hexutil.Big      `json:"blockNumber"`
+	TransactionIndex     *hexutil.Uint64   `json:"transactionIndex"`
+}
+
+func (tx *rpcTransaction) value() *big.Int {
+	if tx.Value == nil {
+		return new(big.Int)
+	}
+	return tx.Value.ToInt()
+}
+
+// pending tells a transaction still in the mempool, which has no block yet.
+func (tx *rpcTransaction) pending() bool {
+	return tx.BlockHash == nil
+}
+
+// effectiveGasPrice is what the transaction pays per gas in a block with the
+// given base fee: min(max fee, base fee + tip), or the gas price of legacy
+// transactions. It stands in for receipts without the field.
+func (tx *rpcTransaction) effectiveGasPrice(baseFee *big.Int) *big.Int {
+	if baseFee != nil && tx.MaxFeePerGas != nil && tx.MaxPriorityFeePerGas != nil {
+		price := new(big.Int).Add(baseFee, tx.MaxPriorityFeePerGas.ToInt())
+		if price.Cmp(tx.MaxFeePerGas.ToInt()) > 0 {
+			price = tx.MaxFeePerGas.ToInt()
+		}
+		return price
+	}
+	if tx.GasPrice != nil {
+		return tx.GasPrice.ToInt()
+	}
+	return new(big.Int)
+}
+
+// fetchTransaction reads a transaction by hash, mined or pending. Like
+// fetchRPCBlock it reads the fields rather than decoding a
+// types.Transaction, which fails on blob transactions.
+func fetchTransaction(ctx context.Context, client *ethclient.Client, hash common.Hash) (*rpcTransaction, error) {
+	var raw json.RawMessage
+	if err := client.Client().CallContext(ctx, &raw, "eth_getTransactionByHash", hash); err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+	tx := &rpcTransaction{}
+	if err := json.Unmarshal(raw, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// fetchRPCBlock reads a block by number, tag or hash; see blockArg. Without
+// full, only transaction hashes are fetched.
+func fetchRPCBlock(ctx context.Context, client *ethclient.Client, method string, arg interface{}, full bool) (*rpcBlock, error) {
+	var raw json.RawMessage
+	if err := client.Client().CallContext(ctx, &raw, method, arg, full); err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+
+	block := &rpcBlock{}
+	if err := json.Unmarshal(raw, &block.header); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, block); err != nil {
+		return nil, err
+	}
+	for _, t := range block.Transactions {
+		if full {
+			tx := &rpcTransaction{}
+			if err := json.Unmarshal(t, tx); err != nil {
+				return nil, err
+			}
+			block.transactions = append(block.transactions, tx)
+			block.txHashes = append(block.txHashes, tx.Hash)
+		} else {
+			var hash common.Hash
+			if err := json.Unmarshal(t, &hash); err != nil {
+				return nil, err
+			}
+			block.txHashes = append(block.txHashes, hash)
+		}
+	}
+	block.Transactions = nil
+	return block, nil
+}
+
+// fetchBlockByNumber is fetchRPCBlock for a block number.
+func fetchBlockByNumber(ctx context.Context, client *ethclient.Client, number *big.Int, full bool) (*rpcBlock, error) {
+	return fetchRPCBlock(ctx, client, "eth_getBlockByNumber", hexutil.EncodeBig(number), full)
+}
+
+// fetchReceipts reads the receipts of transactions in JSON-RPC batches.
+func fetchReceipts(parent context.Context, client *ethclient.Client, cfg EthereumConfig, hashes []common.Hash) ([]*types.Receipt, error) {
+	receipts := make([]*types.Receipt, len(hashes))
+	for start := 0; start < len(hashes); start += cfg.batchSize() {
+		end := start + cfg.batchSize()
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		elems := make([]rpc.BatchElem, 0, end-start)
+		for i := start; i < end; i++ {
+			elems = append(elems, rpc.BatchElem{
+				Method: "eth_getTransactionReceipt",
+				Args:   []interface{}{hashes[i]},
+				Result: &receipts[i],
+			})
+		}
+
+		ctx, cancel := callContext(parent, cfg)
+		err := client.Client().BatchCallContext(ctx, elems)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, elem := range elems {
+			if elem.Error != nil {
+				return nil, elem.Error
+			}
+		}
+	}
+	for _, receipt := range receipts {
+		if receipt == nil {
+			return nil, ethereum.NotFound
+		}
+	}
+	return receipts, nil
+}
+
+func newBlockResponse(block *rpcBlock) BlockResponse {
+	header := block.header
+	response := BlockResponse{
+		Number:           header.Number.Int64(),
+		Hash:             block.Hash().Hex(),
+		ParentHash:       header.ParentHash.Hex(),
+		Sha3Uncles:       header.UncleHash.Hex(),
+		LogsBloom:        hexutil.Encode(header.Bloom.Bytes()),
+		TransactionsRoot: header.TxHash.Hex(),
+		StateRoot:        header.Root.Hex(),
+		ReceiptsRoot:     header.ReceiptHash.Hex(),
+		Miner:            header.Coinbase.Hex(),
+		Difficulty:       header.Difficulty.String(),
+		Size:             uint64(block.Size),
+		GasLimit:         header.GasLimit,
+		GasUsed:          header.GasUsed,
+		Timestamp:        header.Time,
+		ExtraData:        hex.EncodeToString(header.Extra),
+		MixHash:          header.MixDigest.Hex(),
+		Nonce:            hex.EncodeToString(header.Nonce[:]),
+		BaseFeePerGas:    bigString(header.BaseFee),
+		BlobGasUsed:      (*uint64)(block.BlobGasUsed),
+		ExcessBlobGas:    (*uint64)(block.ExcessBlobGas),
+		TransactionCount: len(block.txHashes),
+	}
+	if block.TotalDifficulty != nil {
+		response.TotalDifficulty = bigString(block.TotalDifficulty.ToInt())
+	}
+	if block.ParentBeaconBlockRoot != nil {
+		root := block.ParentBeaconBlockRoot.Hex()
+		response.ParentBeaconBlockRoot = &root
+	}
+	if header.WithdrawalsHash != nil {
+		root := header.WithdrawalsHash.Hex()
+		response.WithdrawalsRoot = &root
+		response.Withdrawals = []WithdrawalResponse{}
+		for _, w := range block.Withdrawals {
+			response.Withdrawals = append(response.Withdrawals, WithdrawalResponse{
+				Index:     w.Index,
+				Validator: w.Validator,
+				Address:   w.Address.Hex(),
+				Amount:    w.Amount,
+			})
+		}
+	}
+	return response
+}
+
+// includeTransactions adds the block's transactions to a response: their
+// hashes, or with include "transactions" or "receipts" the full
+// transactions, and with "receipts" their receipts too.
+func includeTransactions(ctx context.Context, client *ethclient.Client, cfg EthereumConfig, block *rpcBlock, response *BlockResponse, include string) error {
+	if include == "" || include == "hashes" {
+		response.TransactionHashes = make([]string, len(block.txHashes))
+		for i, hash := range block.txHashes {
+			response.TransactionHashes[i] = hash.Hex()
+		}
+		return nil
+	}
+
+	var receipts []*types.Receipt
+	if include == "receipts" {
+		var err error
+		if receipts, err = fetchReceipts(ctx, client, cfg, block.txHashes); err != nil {
+			return err
+		}
+		response.Receipts = receipts
+	}
+
+	response.Transactions = make([]TransactionResponse, len(block.transactions))
+	for i, tx := range block.transactions {
+		var receipt *types.Receipt
+		if receipts != nil {
+			receipt = receipts[i]
+		}
+		response.Transactions[i] = tx.response(block.header.BaseFee, receipt)
+	}
+	return nil
+}
+
+// response converts the transaction to the API's model. Status, contract
+// address and effective gas price need its receipt.
+func (tx *rpcTransaction) response(baseFee *big.Int, receipt *types.Receipt) TransactionResponse {
+	response := TransactionResponse{
+		Type:                 uint8(tx.Type),
+		From:                 tx.From.Hex(),
+		To:                   addressHex(tx.To),
+		Value:                tx.value().String(),
+		MaxFeePerGas:         bigString((*big.Int)(tx.MaxFeePerGas)),
+		MaxPriorityFeePerGas: bigString((*big.Int)(tx.MaxPriorityFeePerGas)),
+		MaxFeePerBlobGas:     bigString((*big.Int)(tx.MaxFeePerBlobGas)),
+		Gas:                  uint64(tx.Gas),
+		Input:                hex.EncodeToString(tx.Input),
+		Nonce:                uint64(tx.Nonce),
+		Hash:                 tx.Hash.Hex(),
+		BlobVersionedHashes:  tx.BlobVersionedHashes,
+	}
+	if tx.GasPrice != nil {
+		response.GasPrice = tx.GasPrice.String()
+	}
+	if tx.AccessList != nil {
+		response.AccessList = *tx.AccessList
+	}
+	if tx.BlockHash != nil {
+		response.BlockHash = tx.BlockHash.Hex()
+	}
+	if tx.BlockNumber != nil {
+		response.BlockNumber = tx.BlockNumber.ToInt().String()
+	}
+	if tx.TransactionIndex != nil {
+		response.TransactionIndex = uint(*tx.TransactionIndex)
+	}
+
+	if receipt == nil {
+		return response
+	}
+	response.Status = receipt.Status
+	if tx.To == nil {
+		response.ContractAddress = addressHex(&receipt.ContractAddress)
+	}
+	if receipt.EffectiveGasPrice != nil {
+		response.EffectiveGasPrice = receipt.EffectiveGasPrice.String()
+	} else {
+		response.EffectiveGasPrice = tx.effectiveGasPrice(baseFee).String()
+	}
+	return response
+}
@@ -0,0 +1,177 @@
+package ethereum
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errInvalidBlock       = errors.New("Invalid block, expected a number, a hash, latest, safe, finalized or pending")
+	errBlockAndTimestamp  = errors.New("Only one of block and timestamp can be given")
+	errBeforeGenesisBlock = errors.New("Timestamp is before the first block")
+	errNegativeTimestamp  = errors.New("Timestamp must not be negative")
+)
+
+// blockSelector is the state a query reads: a block number or tag for
+// BalanceAt, or a block hash. block is the resolved block, nil for the
+// default latest and for pending. It is read with fetchRPCBlock, whose hash
+// comes from the node: rehashing a header decoded by the go-ethereum version
+// in use gives wrong hashes for post-Cancun blocks.
+type blockSelector struct {
+	number *big.Int
+	hash   *common.Hash
+	block  *rpcBlock
+}
+
+// ref describes the resolved block for responses.
+func (s blockSelector) ref() *BlockRef {
+	if s.block == nil {
+		return nil
+	}
+	return &BlockRef{Number: s.block.NumberU64(), Hash: s.block.Hash().Hex(), Timestamp: s.block.header.Time}
+}
+
+// resolveBlockSelector turns a block selector and/or a timestamp into the
+// block to query. Tags other than pending are resolved to a number, so that
+// every address of a multi-address query is read at the same block.
+func resolveBlockSelector(ctx context.Context, client *ethclient.Client, block string, timestamp int64) (blockSelector, error) {
+	var sel blockSelector
+	var err error
+
+	if timestamp < 0 {
+		return sel, errNegativeTimestamp
+	}
+	if timestamp != 0 {
+		if block != "" {
+			return sel, errBlockAndTimestamp
+		}
+		sel.block, err = blockAtTimestamp(ctx, client, uint64(timestamp))
+		if err != nil {
+			return sel, err
+		}
+		sel.number = sel.block.header.Number
+		return sel, nil
+	}
+
+	switch block {
+	case "":
+		return sel, nil
+	case "pending":
+		sel.number = big.NewInt(int64(rpc.PendingBlockNumber))
+		return sel, nil
+	}
+
+	method, arg, err := blockArg(block)
+	if err != nil {
+		return sel, err
+	}
+	sel.block, err = fetchRPCBlock(ctx, client, method, arg, false)
+	if err != nil {
+		return sel, err
+	}
+	if method == "eth_getBlockByHash" {
+		hash := sel.block.Hash()
+		sel.hash = &hash
+	}
+	sel.number = sel.block.header.Number
+	return sel, nil
+}
+
+// arg is the block parameter of eth_ calls. Blocks selected by hash are
+// queried by hash (EIP-1898), so a reorg cannot substitute another block at
+// the same height.
+func (s blockSelector) arg() interface{} {
+	if s.hash != nil {
+		return rpc.BlockNumberOrHashWithHash(*s.hash, true)
+	}
+	if s.number == nil {
+		return "latest"
+	}
+	if s.number.Sign() < 0 {
+		return rpc.BlockNumber(s.number.Int64())
+	}
+	return hexutil.EncodeBig(s.number)
+}
+
+func balanceAt(ctx context.Context, client *ethclient.Client, address common.Address, sel blockSelector) (*big.Int, error) {
+	var result hexutil.Big
+	err := client.Client().CallContext(ctx, &result, "eth_getBalance", address, sel.arg())
+	return (*big.Int)(&result), err
+}
+
+// blockAtTimestamp returns the last block mined at or before timestamp, by
+// binary search over blocks.
+func blockAtTimestamp(ctx context.Context, client *ethclient.Client, timestamp uint64) (*rpcBlock, error) {
+	latest, err := fetchRPCBlock(ctx, client, "eth_getBlockByNumber", "latest", false)
+	if err != nil {
+		return nil, err
+	}
+	if latest.header.Time <= timestamp {
+		return latest, nil
+	}
+
+	lo, hi := uint64(0), latest.NumberU64()
+	var found *rpcBlock
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+		block, err := fetchBlockByNumber(ctx, client, new(big.Int).SetUint64(mid), false)
+		if err != nil {
+			return nil, err
+		}
+		if block.header.Time <= timestamp {
+			found, lo = block, mid+1
+		} else {
+			if mid == 0 {
+				break
+			}
+			hi = mid - 1
+		}
+	}
+	if found == nil {
+		return nil, errBeforeGenesisBlock
+	}
+	return found, nil
+}
+
+func blockSelectorStatus(err error) int {
+	switch err {
+	case errInvalidBlock, errBlockAndTimestamp, errBeforeGenesisBlock, errNegativeTimestamp:
+		return http.StatusBadRequest
+	case ethereum.NotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// blockArg returns the method and argument to fetch a block by number, hash
+// or tag.
+func blockArg(block string) (string, interface{}, error) {
+	switch {
+	case block == "" || block == "latest" || block == "safe" || block == "finalized" || block == "pending" || block == "earliest":
+		if block == "" {
+			block = "latest"
+		}
+		return "eth_getBlockByNumber", block, nil
+	case strings.HasPrefix(block, "0x") && len(block) == 66:
+		return "eth_getBlockByHash", common.HexToHash(block), nil
+	case strings.HasPrefix(block, "0x"):
+		if _, err := hexutil.DecodeUint64(block); err != nil {
+			return "", nil, errInvalidBlock
+		}
+		return "eth_getBlockByNumber", block, nil
+	}
+	n, err := strconv.ParseUint(block, 10, 64)
+	if err != nil {
+		return "", nil, errInvalidBlock
+	}
+	return "eth_getBlockByNumber", hexutil.EncodeUint64(n), nil
+}
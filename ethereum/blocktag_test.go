@@ -0,0 +1,107 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testBlock is a post-Cancun block as the node returns it. The hash is the
+// node's; it does not match the header go-ethereum 1.12 decodes.
+func testBlock(number, time uint64) map[string]interface{} {
+	zero := "0x" + strings.Repeat("00", 32)
+	return map[string]interface{}{
+		"number":                hexutil.EncodeUint64(number),
+		"hash":                  fmt.Sprintf("0x%064x", number+0xb10c),
+		"parentHash":            fmt.Sprintf("0x%064x", number+0xb10c-1),
+		"timestamp":             hexutil.EncodeUint64(time),
+		"sha3Uncles":            zero,
+		"miner":                 "0x" + strings.Repeat("00", 20),
+		"stateRoot":             zero,
+		"transactionsRoot":      zero,
+		"receiptsRoot":          zero,
+		"logsBloom":             "0x" + strings.Repeat("00", 256),
+		"difficulty":            "0x0",
+		"gasLimit":              "0x1c9c380",
+		"gasUsed":               "0x0",
+		"extraData":             "0x",
+		"mixHash":               zero,
+		"nonce":                 "0x0000000000000000",
+		"baseFeePerGas":         "0x7",
+		"withdrawalsRoot":       zero,
+		"withdrawals":           []interface{}{},
+		"blobGasUsed":           "0x0",
+		"excessBlobGas":         "0x0",
+		"parentBeaconBlockRoot": zero,
+		"size":                  "0x200",
+		"transactions":          []interface{}{},
+		"uncles":                []interface{}{},
+	}
+}
+
+// serveChain answers eth_getBlockByNumber for blocks 0 to head, block n
+// being mined at 1000+12n.
+func serveChain(stub *rpcStub, head uint64) {
+	stub.handle("eth_getBlockByNumber", func(params []json.RawMessage) (interface{}, error) {
+		var tag string
+		json.Unmarshal(params[0], &tag)
+		n := head
+		if tag != "latest" {
+			var err error
+			if n, err = hexutil.DecodeUint64(tag); err != nil {
+				return nil, err
+			}
+		}
+		if n > head {
+			return nil, nil
+		}
+		return testBlock(n, 1000+12*n), nil
+	})
+}
+
+func TestBlockSelectorUsesNodeHash(t *testing.T) {
+	stub := newRPCStub(t)
+	serveChain(stub, 100)
+	client, err := getClient(stub.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sel, err := resolveBlockSelector(context.Background(), client, "42", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testBlock(42, 0)["hash"]
+	if ref := sel.ref(); ref == nil || ref.Number != 42 || ref.Hash != want {
+		t.Errorf("ref %+v, want block 42 with hash %s", ref, want)
+	}
+}
+
+func TestBlockSelectorTimestamp(t *testing.T) {
+	stub := newRPCStub(t)
+	serveChain(stub, 100)
+	client, err := getClient(stub.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sel, err := resolveBlockSelector(context.Background(), client, "", 1000+12*30+5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref := sel.ref(); ref == nil || ref.Number != 30 || ref.Hash != testBlock(30, 0)["hash"] {
+		t.Errorf("ref %+v, want block 30", ref)
+	}
+
+	if _, err := resolveBlockSelector(context.Background(), client, "", 999); err != errBeforeGenesisBlock {
+		t.Errorf("before genesis: %v, want %v", err, errBeforeGenesisBlock)
+	}
+	_, err = resolveBlockSelector(context.Background(), client, "", -1)
+	if status := blockSelectorStatus(err); status != http.StatusBadRequest {
+		t.Errorf("negative timestamp: %v, status %d", err, status)
+	}
+}
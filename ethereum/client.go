@@ -0,0 +1,149 @@
+package ethereum
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+	dialTimeout           = 10 * time.Second
+	healthCheckInterval   = 15 * time.Second
+	minReconnectBackoff   = time.Second
+	maxReconnectBackoff   = time.Minute
+)
+
+// node keeps one long-lived connection per endpoint. The connection is dialed
+// on first use and dropped when a health check fails; redials back off
+// exponentially while the endpoint stays unreachable.
+type node struct {
+	url string
+
+	mu       sync.Mutex
+	eth      *ethclient.Client
+	err      error
+	backoff  time.Duration
+	nextDial time.Time
+}
+
+var (
+	nodesMu sync.Mutex
+	nodes   = map[string]*node{}
+)
+
+// endpoint returns the URL to dial. Endpoint accepts ipc://, http(s):// and
+// ws(s):// URLs; the older IPCPath is used when it is not set.
+func (cfg EthereumConfig) endpoint() string {
+	if cfg.Endpoint != "" {
+		return strings.TrimPrefix(cfg.Endpoint, "ipc://")
+	}
+	return cfg.IPCPath
+}
+
+func (cfg EthereumConfig) requestTimeout() time.Duration {
+	if cfg.RequestTimeout > 0 {
+		return time.Duration(cfg.RequestTimeout) * time.Second
+	}
+	return defaultRequestTimeout
+}
+
+// getClient returns the shared client of the configured endpoint. Callers must
+// not close it.
+func getClient(cfg EthereumConfig) (*ethclient.Client, error) {
+	url := cfg.endpoint()
+
+	nodesMu.Lock()
+	n, ok := nodes[url]
+	if !ok {
+		n = &node{url: url, backoff: minReconnectBackoff}
+		nodes[url] = n
+		go n.monitor()
+	}
+	nodesMu.Unlock()
+
+	return n.client()
+}
+
+// callContext bounds a single node call by the configured request timeout.
+func callContext(parent context.Context, cfg EthereumConfig) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, cfg.requestTimeout())
+}
+
+func (n *node) client() (*ethclient.Client, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.eth == nil {
+		n.dialLocked()
+	}
+	if n.eth == nil {
+		return nil, fmt.Errorf("ethereum node %s is unavailable: %v", n.url, n.err)
+	}
+	return n.eth, nil
+}
+
+func (n *node) dialLocked() {
+	if time.Now().Before(n.nextDial) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	rpcClient, err := rpc.DialContext(ctx, n.url)
+	if err != nil {
+		n.err = err
+		n.nextDial = time.Now().Add(n.backoff)
+		n.backoff *= 2
+		if n.backoff > maxReconnectBackoff {
+			n.backoff = maxReconnectBackoff
+		}
+		log.Printf("Error connecting to Ethereum node %s, retrying in %s: %v", n.url, time.Until(n.nextDial).Round(time.Second), err)
+		return
+	}
+
+	n.eth = ethclient.NewClient(rpcClient)
+	n.err = nil
+	n.backoff = minReconnectBackoff
+	n.nextDial = time.Time{}
+}
+
+// monitor pings the node and drops a connection that stopped answering, so
+// that it is redialed instead of failing every request.
+func (n *node) monitor() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n.mu.Lock()
+		eth := n.eth
+		if eth == nil {
+			n.dialLocked()
+			n.mu.Unlock()
+			continue
+		}
+		n.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		_, err := eth.BlockNumber(ctx)
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		log.Printf("Ethereum node %s is not responding, reconnecting: %v", n.url, err)
+		n.mu.Lock()
+		if n.eth == eth {
+			n.eth.Close()
+			n.eth = nil
+			n.err = err
+		}
+		n.mu.Unlock()
+	}
+}
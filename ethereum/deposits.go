@@ -0,0 +1,582 @@
+package ethereum
+
+import (
+	"context"
+	"crypto-api/helper"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The deposit scanner follows the chain block by block from a checkpoint kept
+// in Redis, and records native and ERC-20 transfers to the receiving
+// addresses derived from the configured key. The hashes of the last
+// reorgDepth scanned blocks are kept too: when the next block does not build
+// on the stored hash of its parent, the scanner steps back one block at a
+// time, undoing the deposits of the orphaned blocks, until it is back on the
+// canonical chain. A reorg deeper than that stops the scanner.
+
+const (
+	defaultDepositAddressCount  = 100
+	defaultDepositConfirmations = 12
+	defaultDepositPollInterval  = 12 * time.Second
+	defaultDepositListLimit     = 100
+	reorgDepth                  = 128
+	scannerLockTTL              = time.Minute
+)
+
+var errDeepReorg = fmt.Errorf("reorg deeper than %d blocks", reorgDepth)
+
+type depositAddress struct {
+	Account uint32
+	Index   uint32
+}
+
+type depositScanner struct {
+	cfg       EthereumConfig
+	addresses map[common.Address]depositAddress
+	tokens    []common.Address
+
+	mu      sync.Mutex
+	running bool
+	lastErr error
+	warning string
+}
+
+var (
+	depositScannersMu sync.Mutex
+	depositScanners   = map[string]*depositScanner{}
+)
+
+func (c DepositConfig) accounts() []uint32 {
+	if len(c.Accounts) == 0 {
+		return []uint32{0}
+	}
+	return c.Accounts
+}
+
+func (c DepositConfig) addressCount() uint32 {
+	if c.AddressCount > 0 {
+		return c.AddressCount
+	}
+	return defaultDepositAddressCount
+}
+
+func (c DepositConfig) pollInterval() time.Duration {
+	if c.PollInterval > 0 {
+		return time.Duration(c.PollInterval) * time.Second
+	}
+	return defaultDepositPollInterval
+}
+
+// StartDepositScanner starts scanning in the background when deposits are
+// enabled in the configuration. Only one instance scans at a time; the
+// others wait for its Redis lock to expire.
+func StartDepositScanner(cfg EthereumConfig) {
+	if !cfg.Deposits.Enabled {
+		return
+	}
+	if cfg.XPrv == "" {
+		log.Printf("Deposit scanner is enabled but no xprv is configured, not starting")
+		return
+	}
+
+	addresses, err := depositAddresses(cfg)
+	if err != nil {
+		log.Printf("Error deriving deposit addresses: %v", err)
+		return
+	}
+
+	s := &depositScanner{cfg: cfg, addresses: addresses}
+	for _, t := range cfg.Deposits.Tokens {
+		token, ok := cfg.resolveToken(t)
+		if !ok {
+			log.Printf("Ignoring invalid deposit token address %s", t)
+			continue
+		}
+		s.tokens = append(s.tokens, token)
+	}
+
+	depositScannersMu.Lock()
+	if _, ok := depositScanners[cfg.network()]; ok {
+		depositScannersMu.Unlock()
+		return
+	}
+	depositScanners[cfg.network()] = s
+	depositScannersMu.Unlock()
+
+	log.Printf("Deposit scanner watching %d addresses", len(addresses))
+	go s.run()
+}
+
+// depositAddresses derives the receiving addresses of the configured
+// accounts, at the same paths /send signs with.
+func depositAddresses(cfg EthereumConfig) (map[common.Address]depositAddress, error) {
+	addresses := map[common.Address]depositAddress{}
+	count := cfg.Deposits.addressCount()
+	for _, account := range cfg.Deposits.accounts() {
+		xpub, err := helper.DeriveExtendedPubKey(cfg.XPrv, fmt.Sprintf("%d'", account))
+		if err != nil {
+			return nil, err
+		}
+		pubKeys, err := helper.DerivePubKeys(xpub, 0, 0, count-1)
+		if err != nil {
+			return nil, err
+		}
+		for i, pubKey := range pubKeys {
+			key, err := crypto.DecompressPubkey(pubKey)
+			if err != nil {
+				return nil, err
+			}
+			addresses[crypto.PubkeyToAddress(*key)] = depositAddress{Account: account, Index: uint32(i)}
+		}
+	}
+	return addresses, nil
+}
+
+func (s *depositScanner) run() {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+
+	for {
+		locked, err := s.holdLock(token)
+		caughtUp := true
+		if err == nil && locked {
+			caughtUp, err = s.step()
+		}
+
+		s.mu.Lock()
+		s.running, s.lastErr = locked, err
+		s.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Deposit scanner: %v", err)
+		}
+		if caughtUp {
+			time.Sleep(s.cfg.Deposits.pollInterval())
+		}
+	}
+}
+
+func (s *depositScanner) holdLock(token string) (bool, error) {
+	return holdRedisLock(s.cfg.redisKey("scanner", "lock"), token, scannerLockTTL)
+}
+
+func (s *depositScanner) checkpoint() (uint64, bool, error) {
+	n, err := rdb.Get(ctx, s.cfg.redisKey("scanner", "checkpoint")).Uint64()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	return n, err == nil, err
+}
+
+// step scans the block after the checkpoint, or steps back one block on a
+// reorg. It reports whether the scanner has caught up with the head.
+func (s *depositScanner) step() (bool, error) {
+	client, err := getClient(s.cfg)
+	if err != nil {
+		return true, err
+	}
+
+	ctx, cancel := callContext(context.Background(), s.cfg)
+	defer cancel()
+
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return true, err
+	}
+
+	checkpoint, ok, err := s.checkpoint()
+	if err != nil {
+		return true, err
+	}
+	next := checkpoint + 1
+	if !ok {
+		// Without a checkpoint, start at the configured block or the head.
+		next = head
+		if s.cfg.Deposits.StartBlock > 0 {
+			next = s.cfg.Deposits.StartBlock
+		}
+	}
+	if next > head {
+		return true, nil
+	}
+
+	block, err := fetchBlockByNumber(ctx, client, new(big.Int).SetUint64(next), true)
+	if err != nil {
+		return true, err
+	}
+
+	if ok && next > 0 {
+		parent, err := rdb.HGet(ctx, s.cfg.redisKey("scanner", "blocks"), strconv.FormatUint(next-1, 10)).Result()
+		if err == redis.Nil {
+			// Undoing has gone past the reorgDepth stored hashes, so the
+			// chain cannot be followed back to where it forked. Scanning on
+			// would keep deposits of orphaned blocks; the checkpoint stays
+			// until someone looks into it.
+			return true, fmt.Errorf("%w: no hash stored for block %d, stopped at checkpoint %d", errDeepReorg, next-1, checkpoint)
+		}
+		if err != nil {
+			return true, err
+		}
+		if parent != block.ParentHash().Hex() {
+			log.Printf("Deposit scanner: reorg at block %d, undoing block %d", next, next-1)
+			return false, s.undo(next - 1)
+		}
+	}
+
+	deposits, err := s.blockDeposits(ctx, client, block)
+	if err != nil {
+		return true, err
+	}
+	return next == head, s.record(block, deposits)
+}
+
+func (s *depositScanner) blockDeposits(ctx context.Context, client *ethclient.Client, block *rpcBlock) ([]Deposit, error) {
+	var deposits []Deposit
+	now := time.Now().Unix()
+
+	for _, tx := range block.transactions {
+		if tx.To == nil || tx.value().Sign() <= 0 {
+			continue
+		}
+		owner, ok := s.addresses[*tx.To]
+		if !ok {
+			continue
+		}
+		receipt, err := client.TransactionReceipt(ctx, tx.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			continue
+		}
+		deposits = append(deposits, Deposit{
+			ID:          tx.Hash.Hex() + "-native",
+			Address:     tx.To.Hex(),
+			Account:     owner.Account,
+			Index:       owner.Index,
+			From:        tx.From.Hex(),
+			Value:       tx.value().String(),
+			Amount:      formatUnits(tx.value(), 18),
+			TxHash:      tx.Hash.Hex(),
+			BlockNumber: block.NumberU64(),
+			BlockHash:   block.Hash().Hex(),
+			SeenAt:      now,
+		})
+	}
+
+	internal, err := s.internalDeposits(ctx, client, block, now)
+	if err != nil {
+		return nil, err
+	}
+	deposits = append(deposits, internal...)
+
+	// All transfers of the block are matched locally: a topic filter on
+	// every derived address would be too large for most nodes.
+	hash := block.Hash()
+	logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{
+		BlockHash: &hash,
+		Addresses: s.tokens,
+		Topics:    [][]common.Hash{{transferEventTopic}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range logs {
+		if len(l.Topics) != 3 || len(l.Data) != 32 {
+			continue
+		}
+		to := common.BytesToAddress(l.Topics[2].Bytes())
+		owner, ok := s.addresses[to]
+		if !ok {
+			continue
+		}
+		value := new(big.Int).SetBytes(l.Data)
+		logIndex := l.Index
+		deposit := Deposit{
+			ID:          fmt.Sprintf("%s-%d", l.TxHash.Hex(), l.Index),
+			Address:     to.Hex(),
+			Account:     owner.Account,
+			Index:       owner.Index,
+			From:        common.BytesToAddress(l.Topics[1].Bytes()).Hex(),
+			Token:       l.Address.Hex(),
+			Value:       value.String(),
+			TxHash:      l.TxHash.Hex(),
+			LogIndex:    &logIndex,
+			BlockNumber: block.NumberU64(),
+			BlockHash:   block.Hash().Hex(),
+			SeenAt:      now,
+		}
+		if metadata, err := getTokenMetadata(ctx, client, s.cfg, l.Address); err == nil {
+			deposit.Symbol = metadata.Symbol
+			deposit.Amount = formatUnits(value, metadata.Decimals)
+		}
+		deposits = append(deposits, deposit)
+	}
+
+	return deposits, nil
+}
+
+// internalDeposits finds ether sent to our addresses by contract calls,
+// when traces are enabled. On a node without the debug API the scanner
+// carries on without them and reports a warning.
+func (s *depositScanner) internalDeposits(ctx context.Context, client *ethclient.Client, block *rpcBlock, now int64) ([]Deposit, error) {
+	s.mu.Lock()
+	disabled := s.warning != ""
+	s.mu.Unlock()
+	if !s.cfg.Deposits.Traces || disabled {
+		return nil, nil
+	}
+
+	traces, err := traceBlock(ctx, client, block.NumberU64())
+	if traceUnsupported(err) {
+		log.Printf("Deposit scanner: traces unavailable, internal deposits are not detected: %v", err)
+		s.mu.Lock()
+		s.warning = "traces unavailable, internal deposits are not detected: " + err.Error()
+		s.mu.Unlock()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var deposits []Deposit
+	for _, trace := range traces {
+		for i, transfer := range internalTransfers(trace.TxHash, trace.Result) {
+			to := common.HexToAddress(transfer.To)
+			owner, ok := s.addresses[to]
+			if !ok {
+				continue
+			}
+			deposits = append(deposits, Deposit{
+				ID:          fmt.Sprintf("%s-internal-%d", transfer.TxHash, i),
+				Address:     to.Hex(),
+				Account:     owner.Account,
+				Index:       owner.Index,
+				From:        transfer.From,
+				Value:       transfer.Value,
+				Amount:      transfer.Amount,
+				TxHash:      transfer.TxHash,
+				Internal:    true,
+				BlockNumber: block.NumberU64(),
+				BlockHash:   block.Hash().Hex(),
+				SeenAt:      now,
+			})
+		}
+	}
+	return deposits, nil
+}
+
+// record stores the deposits of a block together with its hash and the new
+// checkpoint, in one transaction.
+func (s *depositScanner) record(block *rpcBlock, deposits []Deposit) error {
+	number := block.NumberU64()
+	height := strconv.FormatUint(number, 10)
+
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, deposit := range deposits {
+			record, err := json.Marshal(deposit)
+			if err != nil {
+				return err
+			}
+			member := &redis.Z{Score: float64(number), Member: deposit.ID}
+			pipe.HSet(ctx, s.cfg.redisKey("deposits"), deposit.ID, record)
+			pipe.ZAdd(ctx, s.cfg.redisKey("deposits", "index"), member)
+			pipe.ZAdd(ctx, s.cfg.redisKey("deposits", "address", deposit.Address), member)
+			pipe.SAdd(ctx, s.cfg.redisKey("deposits", "block", height), deposit.ID)
+		}
+		pipe.HSet(ctx, s.cfg.redisKey("scanner", "blocks"), height, block.Hash().Hex())
+		if number >= reorgDepth {
+			old := strconv.FormatUint(number-reorgDepth, 10)
+			pipe.HDel(ctx, s.cfg.redisKey("scanner", "blocks"), old)
+			pipe.Del(ctx, s.cfg.redisKey("deposits", "block", old))
+		}
+		pipe.Set(ctx, s.cfg.redisKey("scanner", "checkpoint"), number, 0)
+		return nil
+	})
+	return err
+}
+
+// undo removes the deposits of an orphaned block and moves the checkpoint
+// before it.
+func (s *depositScanner) undo(number uint64) error {
+	height := strconv.FormatUint(number, 10)
+	ids, err := rdb.SMembers(ctx, s.cfg.redisKey("deposits", "block", height)).Result()
+	if err != nil {
+		return err
+	}
+	deposits, err := loadDeposits(s.cfg, ids)
+	if err != nil {
+		return err
+	}
+
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, deposit := range deposits {
+			pipe.HDel(ctx, s.cfg.redisKey("deposits"), deposit.ID)
+			pipe.ZRem(ctx, s.cfg.redisKey("deposits", "index"), deposit.ID)
+			pipe.ZRem(ctx, s.cfg.redisKey("deposits", "address", deposit.Address), deposit.ID)
+		}
+		pipe.Del(ctx, s.cfg.redisKey("deposits", "block", height))
+		pipe.HDel(ctx, s.cfg.redisKey("scanner", "blocks"), height)
+		if number == 0 {
+			pipe.Del(ctx, s.cfg.redisKey("scanner", "checkpoint"))
+		} else {
+			pipe.Set(ctx, s.cfg.redisKey("scanner", "checkpoint"), number-1, 0)
+		}
+		return nil
+	})
+	return err
+}
+
+func loadDeposits(cfg EthereumConfig, ids []string) ([]Deposit, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	records, err := rdb.HMGet(ctx, cfg.redisKey("deposits"), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	deposits := make([]Deposit, 0, len(records))
+	for _, record := range records {
+		s, ok := record.(string)
+		if !ok {
+			continue
+		}
+		var deposit Deposit
+		if err := json.Unmarshal([]byte(s), &deposit); err != nil {
+			return nil, err
+		}
+		deposits = append(deposits, deposit)
+	}
+	return deposits, nil
+}
+
+func registerDepositHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/deposits", func(w http.ResponseWriter, r *http.Request) {
+		getDeposits(w, r, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/deposits/scanner", func(w http.ResponseWriter, r *http.Request) {
+		getDepositScannerStatus(w, r, cfg)
+	}).Methods("GET")
+}
+
+// getDeposits lists the newest deposits, optionally of one address, with
+// their current number of confirmations.
+func getDeposits(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	query := r.URL.Query()
+
+	limit := int64(defaultDepositListLimit)
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	index := cfg.redisKey("deposits", "index")
+	if address := query.Get("address"); address != "" {
+		if !common.IsHexAddress(address) {
+			http.Error(w, "Invalid address", http.StatusBadRequest)
+			return
+		}
+		index = cfg.redisKey("deposits", "address", common.HexToAddress(address).Hex())
+	}
+
+	ids, err := rdb.ZRevRange(ctx, index, 0, limit-1).Result()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	deposits, err := loadDeposits(cfg, ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	callCtx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	head, err := client.BlockNumber(callCtx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	required := cfg.depositConfirmations()
+	onlyConfirmed := query.Get("confirmed") == "true"
+	response := DepositsResponse{Head: head, RequiredConfirmations: required, Deposits: []Deposit{}}
+	for _, deposit := range deposits {
+		if head >= deposit.BlockNumber {
+			deposit.Confirmations = head - deposit.BlockNumber + 1
+		}
+		deposit.Confirmed = deposit.Confirmations >= required
+		if onlyConfirmed && !deposit.Confirmed {
+			continue
+		}
+		response.Deposits = append(response.Deposits, deposit)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func getDepositScannerStatus(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	status := DepositScannerStatus{Enabled: cfg.Deposits.Enabled}
+
+	depositScannersMu.Lock()
+	s := depositScanners[cfg.network()]
+	depositScannersMu.Unlock()
+	if s != nil {
+		s.mu.Lock()
+		status.Running = s.running
+		status.Warning = s.warning
+		if s.lastErr != nil {
+			status.Error = s.lastErr.Error()
+		}
+		s.mu.Unlock()
+		status.Addresses = len(s.addresses)
+	}
+
+	checkpoint, ok, err := (&depositScanner{cfg: cfg}).checkpoint()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		status.Checkpoint = &checkpoint
+	}
+
+	if client, err := getClient(cfg); err == nil {
+		callCtx, cancel := callContext(r.Context(), cfg)
+		if head, err := client.BlockNumber(callCtx); err == nil {
+			status.Head = head
+		}
+		cancel()
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,134 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"testing"
+)
+
+const testDepositAddress = "0x6666666666666666666666666666666666666666"
+
+func chainHash(fork byte, number uint64) string {
+	return fmt.Sprintf("0x%02x%062x", fork, number)
+}
+
+// chainBlock is block number of a fork, building on parentFork.
+func chainBlock(number uint64, fork, parentFork byte, txs ...interface{}) map[string]interface{} {
+	block := testBlock(number, 1000+12*number)
+	block["hash"] = chainHash(fork, number)
+	block["parentHash"] = chainHash(parentFork, number-1)
+	block["transactions"] = append([]interface{}{}, txs...)
+	return block
+}
+
+// depositTransaction sends 1 ether to the deposit address in block number
+// of a fork.
+func depositTransaction(number uint64, fork byte) map[string]interface{} {
+	return map[string]interface{}{
+		"type":             "0x0",
+		"hash":             testTxHash,
+		"from":             testFrom,
+		"to":               testDepositAddress,
+		"value":            "0xde0b6b3a7640000",
+		"nonce":            "0x1",
+		"gas":              "0x5208",
+		"gasPrice":         "0x3b9aca00",
+		"input":            "0x",
+		"v":                "0x25",
+		"r":                "0x1",
+		"s":                "0x1",
+		"blockHash":        chainHash(fork, number),
+		"blockNumber":      hexutil.EncodeUint64(number),
+		"transactionIndex": "0x0",
+	}
+}
+
+// serveBlocks makes the blocks the chain, the last one being the head.
+func serveBlocks(stub *rpcStub, blocks ...map[string]interface{}) {
+	byNumber := map[string]map[string]interface{}{}
+	for _, block := range blocks {
+		byNumber[block["number"].(string)] = block
+	}
+	stub.reply("eth_blockNumber", blocks[len(blocks)-1]["number"])
+	stub.handle("eth_getBlockByNumber", func(params []json.RawMessage) (interface{}, error) {
+		var number string
+		json.Unmarshal(params[0], &number)
+		if block, ok := byNumber[number]; ok {
+			return block, nil
+		}
+		return nil, nil
+	})
+}
+
+func newTestDepositScanner(cfg EthereumConfig) *depositScanner {
+	cfg.Deposits = DepositConfig{Enabled: true, StartBlock: 10}
+	return &depositScanner{
+		cfg:       cfg,
+		addresses: map[common.Address]depositAddress{common.HexToAddress(testDepositAddress): {Account: 0, Index: 3}},
+	}
+}
+
+func TestDepositScannerReorg(t *testing.T) {
+	useRedisStub(t)
+	stub := newRPCStub(t)
+	stub.reply("eth_getTransactionReceipt", testReceipt())
+	stub.reply("eth_getLogs", []interface{}{})
+	s := newTestDepositScanner(stub.config())
+
+	serveBlocks(stub, chainBlock(10, 0xa, 0xa), chainBlock(11, 0xa, 0xa, depositTransaction(11, 0xa)))
+	for i := 0; i < 2; i++ {
+		if _, err := s.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids, err := rdb.ZRange(ctx, s.cfg.redisKey("deposits", "index"), 0, -1).Result()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("deposits %v, %v; want the deposit of block 11", ids, err)
+	}
+
+	// Block 11 is replaced by one without the deposit.
+	serveBlocks(stub, chainBlock(10, 0xa, 0xa), chainBlock(11, 0xb, 0xa), chainBlock(12, 0xb, 0xb))
+	for i := 0; i < 3; i++ {
+		if _, err := s.step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if checkpoint, _, err := s.checkpoint(); err != nil || checkpoint != 12 {
+		t.Errorf("checkpoint %d, %v; want 12", checkpoint, err)
+	}
+	if hash, _ := rdb.HGet(ctx, s.cfg.redisKey("scanner", "blocks"), "11").Result(); hash != chainHash(0xb, 11) {
+		t.Errorf("block 11 is %s, want %s", hash, chainHash(0xb, 11))
+	}
+	if ids, _ := rdb.ZRange(ctx, s.cfg.redisKey("deposits", "index"), 0, -1).Result(); len(ids) != 0 {
+		t.Errorf("deposits %v left from the orphaned block", ids)
+	}
+	address := common.HexToAddress(testDepositAddress).Hex()
+	if ids, _ := rdb.ZRange(ctx, s.cfg.redisKey("deposits", "address", address), 0, -1).Result(); len(ids) != 0 {
+		t.Errorf("deposits %v of %s left from the orphaned block", ids, address)
+	}
+}
+
+func TestDepositScannerDeepReorg(t *testing.T) {
+	useRedisStub(t)
+	stub := newRPCStub(t)
+	stub.reply("eth_getLogs", []interface{}{})
+	s := newTestDepositScanner(stub.config())
+
+	// The hash of the checkpoint was undone past what is stored.
+	rdb.Set(ctx, s.cfg.redisKey("scanner", "checkpoint"), 50, 0)
+	serveBlocks(stub, chainBlock(50, 0xb, 0xb), chainBlock(51, 0xb, 0xb))
+
+	if _, err := s.step(); !errors.Is(err, errDeepReorg) {
+		t.Fatalf("got %v, want %v", err, errDeepReorg)
+	}
+	if checkpoint, _, _ := s.checkpoint(); checkpoint != 50 {
+		t.Errorf("checkpoint moved to %d", checkpoint)
+	}
+	if n, _ := rdb.HLen(ctx, s.cfg.redisKey("scanner", "blocks")).Result(); n != 0 {
+		t.Errorf("%d blocks recorded after a deep reorg", n)
+	}
+}
@@ -0,0 +1,340 @@
+package ethereum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gorilla/mux"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const erc20ABIJSON = `[
+	{"constant":true,"inputs":[{"name":"owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"type":"function"},
+	{"constant":false,"inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"type":"function"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Transfer","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"owner","type":"address"},{"indexed":true,"name":"spender","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Approval","type":"event"}
+]`
+
+var (
+	erc20ABI           = mustParseABI(erc20ABIJSON)
+	transferEventTopic = erc20ABI.Events["Transfer"].ID
+)
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// TokenMetadata never changes for a deployed contract, so it is cached per
+// endpoint and contract for the life of the process.
+type TokenMetadata struct {
+	Address  string `json:"address"`
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+}
+
+var (
+	tokenMetadataMu    sync.RWMutex
+	tokenMetadataCache = map[string]TokenMetadata{}
+)
+
+func registerTokenHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/token/balance", func(w http.ResponseWriter, r *http.Request) {
+		getTokenBalances(w, r, cfg)
+	}).Methods("POST")
+
+	router.HandleFunc("/token/metadata", func(w http.ResponseWriter, r *http.Request) {
+		getTokenMetadataHandler(w, r, cfg)
+	}).Methods("POST")
+
+	router.HandleFunc("/token/transfers", func(w http.ResponseWriter, r *http.Request) {
+		getTokenTransfers(w, r, cfg)
+	}).Methods("POST")
+}
+
+func getTokenMetadata(ctx context.Context, client *ethclient.Client, cfg EthereumConfig, token common.Address) (TokenMetadata, error) {
+	key := cfg.endpoint() + "/" + token.Hex()
+
+	tokenMetadataMu.RLock()
+	metadata, ok := tokenMetadataCache[key]
+	tokenMetadataMu.RUnlock()
+	if ok {
+		return metadata, nil
+	}
+
+	metadata = TokenMetadata{Address: token.Hex()}
+
+	out, err := callToken(ctx, client, token, "decimals")
+	if err != nil {
+		return metadata, err
+	}
+	if err := erc20ABI.UnpackIntoInterface(&metadata.Decimals, "decimals", out); err != nil {
+		return metadata, err
+	}
+
+	// name and symbol are optional in ERC-20 and some early tokens return
+	// bytes32 instead of string, so failures leave them empty.
+	if out, err := callToken(ctx, client, token, "symbol"); err == nil {
+		metadata.Symbol = unpackTokenString("symbol", out)
+	}
+	if out, err := callToken(ctx, client, token, "name"); err == nil {
+		metadata.Name = unpackTokenString("name", out)
+	}
+
+	tokenMetadataMu.Lock()
+	tokenMetadataCache[key] = metadata
+	tokenMetadataMu.Unlock()
+
+	return metadata, nil
+}
+
+func callToken(ctx context.Context, client *ethclient.Client, token common.Address, method string, args ...interface{}) ([]byte, error) {
+	data, err := erc20ABI.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	return client.CallContract(ctx, ethereum.CallMsg{To: &token, Data: data}, nil)
+}
+
+func unpackTokenString(method string, out []byte) string {
+	var s string
+	if err := erc20ABI.UnpackIntoInterface(&s, method, out); err == nil {
+		return s
+	}
+	if len(out) == 32 {
+		return string(bytes.TrimRight(out, "\x00"))
+	}
+	return ""
+}
+
+func getTokenBalance(ctx context.Context, client *ethclient.Client, token, owner common.Address) (*big.Int, error) {
+	out, err := callToken(ctx, client, token, "balanceOf", owner)
+	if err != nil {
+		return nil, err
+	}
+	balance := new(big.Int)
+	if err := erc20ABI.UnpackIntoInterface(&balance, "balanceOf", out); err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+func getTokenBalances(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req TokenBalanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !common.IsHexAddress(req.Address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tokens) == 0 {
+		http.Error(w, "At least one token is required", http.StatusBadRequest)
+		return
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	owner := common.HexToAddress(req.Address)
+	response := TokenBalanceResponse{Address: owner.Hex(), Balances: []TokenBalance{}}
+	for _, t := range req.Tokens {
+		token, ok := cfg.resolveToken(t)
+		if !ok {
+			response.Balances = append(response.Balances, TokenBalance{Token: t, Error: "invalid token address"})
+			continue
+		}
+
+		ctx, cancel := callContext(r.Context(), cfg)
+		balance := TokenBalance{Token: token.Hex()}
+		metadata, err := getTokenMetadata(ctx, client, cfg, token)
+		if err == nil {
+			var raw *big.Int
+			raw, err = getTokenBalance(ctx, client, token, owner)
+			if err == nil {
+				balance.Symbol = metadata.Symbol
+				balance.Decimals = metadata.Decimals
+				balance.Raw = raw.String()
+				balance.Balance = formatUnits(raw, metadata.Decimals)
+			}
+		}
+		cancel()
+		if err != nil {
+			balance.Error = err.Error()
+		}
+		response.Balances = append(response.Balances, balance)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func getTokenMetadataHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req TokenMetadataRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, ok := cfg.resolveToken(req.Token)
+	if !ok {
+		http.Error(w, "Invalid token address", http.StatusBadRequest)
+		return
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	metadata, err := getTokenMetadata(ctx, client, cfg, token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(metadata)
+}
+
+// getTokenTransfers lists the ERC-20 Transfer events sent or received by an
+// address over a block range, optionally restricted to some tokens. Ranges
+// are capped like those of /logs.
+func getTokenTransfers(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req TokenTransfersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !common.IsHexAddress(req.Address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	fromBlock, err := strconv.ParseUint(req.FromBlock, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid fromblock", http.StatusBadRequest)
+		return
+	}
+
+	var tokens []common.Address
+	for _, t := range req.Tokens {
+		token, ok := cfg.resolveToken(t)
+		if !ok {
+			http.Error(w, "Invalid token address "+t, http.StatusBadRequest)
+			return
+		}
+		tokens = append(tokens, token)
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	toBlock, err := resolveToBlock(r.Context(), client, cfg, req.ToBlock)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if toBlock < fromBlock {
+		http.Error(w, "toblock is before fromblock", http.StatusBadRequest)
+		return
+	}
+	if toBlock-fromBlock+1 > uint64(cfg.maxLogRange()) {
+		http.Error(w, fmt.Sprintf("Block range is limited to %d blocks", cfg.maxLogRange()), http.StatusBadRequest)
+		return
+	}
+
+	// Logs can only be filtered on one topic position at a time, so sent
+	// and received transfers are two queries.
+	addressTopic := common.BytesToHash(common.HexToAddress(req.Address).Bytes())
+	var logs []types.Log
+	for _, topics := range [][][]common.Hash{
+		{{transferEventTopic}, {addressTopic}},
+		{{transferEventTopic}, nil, {addressTopic}},
+	} {
+		query := ethereum.FilterQuery{Addresses: tokens, Topics: topics}
+		found, err := filterLogsSplit(r.Context(), client, cfg, query, fromBlock, toBlock, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		logs = append(logs, found...)
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+
+	owner := common.HexToAddress(req.Address)
+	transfers := []TokenTransfer{}
+	seen := map[string]bool{}
+	for _, l := range logs {
+		// ERC-721 uses the same event signature with the token id as a
+		// third indexed topic; those are not fungible transfers.
+		if len(l.Topics) != 3 || len(l.Data) != 32 {
+			continue
+		}
+		key := fmt.Sprintf("%s/%d", l.TxHash.Hex(), l.Index)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		transfer := TokenTransfer{
+			Token:       l.Address.Hex(),
+			From:        common.BytesToAddress(l.Topics[1].Bytes()).Hex(),
+			To:          common.BytesToAddress(l.Topics[2].Bytes()).Hex(),
+			Raw:         new(big.Int).SetBytes(l.Data).String(),
+			BlockNumber: l.BlockNumber,
+			TxHash:      l.TxHash.Hex(),
+			LogIndex:    l.Index,
+		}
+		if transfer.To == owner.Hex() {
+			transfer.Direction = "in"
+		} else {
+			transfer.Direction = "out"
+		}
+		if metadata, err := getTokenMetadata(ctx, client, cfg, l.Address); err == nil {
+			transfer.Symbol = metadata.Symbol
+			transfer.Amount = formatUnits(new(big.Int).SetBytes(l.Data), metadata.Decimals)
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	json.NewEncoder(w).Encode(TokenTransfersResponse{Transfers: transfers})
+}
@@ -0,0 +1,249 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gorilla/mux"
+	"io"
+	"math/big"
+	"net/http"
+)
+
+const (
+	feeHistoryBlocks = 20
+	defaultGasLimit  = 21000
+	defaultFeeTier   = "standard"
+)
+
+// Priority fee tiers are the mean of the given reward percentile over the
+// last feeHistoryBlocks blocks.
+var feeTiers = []struct {
+	name       string
+	percentile float64
+}{
+	{"slow", 10},
+	{"standard", 50},
+	{"fast", 90},
+}
+
+// feeQuote holds the fees of an EIP-1559 chain: the base fee of the next
+// block and the tip of each tier. /fees quotes it and /send pays it, so a
+// transaction sent at a tier has the fee cap /fees quoted for it.
+type feeQuote struct {
+	nextBaseFee *big.Int
+	tips        []*big.Int // by feeTiers index
+}
+
+func registerFeeHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/fees", func(w http.ResponseWriter, r *http.Request) {
+		getFees(w, r, cfg)
+	}).Methods("GET", "POST")
+}
+
+// getFees quotes the cost of a transaction. The call in the request body is
+// optional; without it the gas limit is that of a plain ether transfer.
+func getFees(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req FeesRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	msg, err := req.callMsg()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	response := FeesResponse{GasLimit: req.GasLimit, Tiers: []FeeTier{}}
+	if response.GasLimit == 0 {
+		response.GasLimit = defaultGasLimit
+		if msg.To != nil || len(msg.Data) > 0 {
+			response.GasLimit, err = client.EstimateGas(ctx, msg)
+			if err != nil {
+				http.Error(w, "Error estimating gas: "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+	}
+
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	value := msg.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	gas := new(big.Int).SetUint64(response.GasLimit)
+
+	// Chains without EIP-1559 get a single tier at the node's gas price.
+	if head.BaseFee == nil {
+		gasPrice, err := client.SuggestGasPrice(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Legacy = true
+		response.GasPrice = gasPrice.String()
+		response.Tiers = append(response.Tiers, newFeeTier("standard", gas, value, gasPrice, gasPrice, gasPrice))
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	quote, err := quoteFees(ctx, client, head)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.BaseFee = head.BaseFee.String()
+	response.NextBaseFee = quote.nextBaseFee.String()
+
+	for _, tier := range feeTiers {
+		tip, maxFee, _ := quote.tier(tier.name)
+		expected := new(big.Int).Add(quote.nextBaseFee, tip)
+		response.Tiers = append(response.Tiers, newFeeTier(tier.name, gas, value, tip, expected, maxFee))
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func quoteFees(ctx context.Context, client *ethclient.Client, head *types.Header) (*feeQuote, error) {
+	percentiles := make([]float64, len(feeTiers))
+	for i, tier := range feeTiers {
+		percentiles[i] = tier.percentile
+	}
+	history, err := client.FeeHistory(ctx, feeHistoryBlocks, nil, percentiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// The last base fee of the history is the one of the next block.
+	quote := &feeQuote{nextBaseFee: head.BaseFee}
+	if len(history.BaseFee) > 0 {
+		quote.nextBaseFee = history.BaseFee[len(history.BaseFee)-1]
+	}
+
+	// Without any tips in the history, e.g. after empty blocks, the node's
+	// suggestion is used rather than a zero tip it may not accept.
+	var suggested *big.Int
+	for i := range feeTiers {
+		tip := meanReward(history.Reward, i)
+		if tip.Sign() == 0 {
+			if suggested == nil {
+				if suggested, err = client.SuggestGasTipCap(ctx); err != nil {
+					return nil, err
+				}
+			}
+			tip = suggested
+		}
+		quote.tips = append(quote.tips, tip)
+	}
+	return quote, nil
+}
+
+// tier returns the tip and the fee cap of the named tier. The cap leaves
+// room for the base fee to double before the transaction is included.
+func (q *feeQuote) tier(name string) (*big.Int, *big.Int, bool) {
+	for i, tier := range feeTiers {
+		if tier.name == name {
+			tip := q.tips[i]
+			maxFee := new(big.Int).Add(new(big.Int).Mul(q.nextBaseFee, big.NewInt(2)), tip)
+			return tip, maxFee, true
+		}
+	}
+	return nil, nil, false
+}
+
+func validFeeTier(name string) bool {
+	for _, tier := range feeTiers {
+		if tier.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// meanReward averages one percentile column of a fee history, leaving out
+// empty blocks, which report a zero reward.
+func meanReward(rewards [][]*big.Int, column int) *big.Int {
+	sum, count := new(big.Int), int64(0)
+	for _, block := range rewards {
+		if column >= len(block) || block[column] == nil || block[column].Sign() == 0 {
+			continue
+		}
+		sum.Add(sum, block[column])
+		count++
+	}
+	if count == 0 {
+		return sum
+	}
+	return sum.Div(sum, big.NewInt(count))
+}
+
+// newFeeTier prices gas units at the expected and at the maximum fee per gas.
+// Costs include the transferred value.
+func newFeeTier(name string, gas, value, tip, expected, maxFee *big.Int) FeeTier {
+	cost := new(big.Int).Add(new(big.Int).Mul(gas, expected), value)
+	maxCost := new(big.Int).Add(new(big.Int).Mul(gas, maxFee), value)
+	return FeeTier{
+		Name:                 name,
+		MaxPriorityFeePerGas: tip.String(),
+		MaxFeePerGas:         maxFee.String(),
+		EstimatedCost:        cost.String(),
+		EstimatedCostEther:   formatUnits(cost, 18),
+		MaxCost:              maxCost.String(),
+		MaxCostEther:         formatUnits(maxCost, 18),
+	}
+}
+
+func (req FeesRequest) callMsg() (ethereum.CallMsg, error) {
+	var msg ethereum.CallMsg
+	if req.From != "" {
+		if !common.IsHexAddress(req.From) {
+			return msg, errors.New("Invalid from address")
+		}
+		msg.From = common.HexToAddress(req.From)
+	}
+	if req.To != "" {
+		if !common.IsHexAddress(req.To) {
+			return msg, errors.New("Invalid to address")
+		}
+		to := common.HexToAddress(req.To)
+		msg.To = &to
+	}
+	if req.Value != "" {
+		value, ok := new(big.Int).SetString(req.Value, 10)
+		if !ok || value.Sign() < 0 {
+			return msg, errors.New("Invalid value")
+		}
+		msg.Value = value
+	}
+	if req.Data != "" {
+		data, err := hexutil.Decode(req.Data)
+		if err != nil {
+			return msg, errors.New("Invalid data")
+		}
+		msg.Data = data
+	}
+	return msg, nil
+}
@@ -2,11 +2,11 @@ package ethereum
 
 import (
 	"context"
-	"encoding/hex"
+	"crypto-api/helper"
 	"encoding/json"
 	"fmt"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
@@ -49,9 +49,23 @@ func RegisterHandlers(router *mux.Router, cfg EthereumConfig) {
 		getMultiBalance(w, r, cfg)
 	}).Methods("POST")
 
-	router.HandleFunc("/uniqueaddresses", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/uniqueaddresses", helper.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) {
 		getAllTransactionAddressesHandler(w, r, cfg)
-	}).Methods("POST")
+	})).Methods("POST")
+
+	registerTokenHandlers(router, cfg)
+	registerSendHandlers(router, cfg)
+	registerNonceHandlers(router, cfg)
+	registerFeeHandlers(router, cfg)
+	registerLogHandlers(router, cfg)
+	registerDepositHandlers(router, cfg)
+	registerJobHandlers(router, cfg)
+	registerAddressHandlers(router, cfg)
+	registerTraceHandlers(router, cfg)
+	registerReceiptHandlers(router, cfg)
+	registerWatchHandlers(router, cfg)
+	registerSubscriptionHandlers(router, cfg)
+	registerNetworkHandlers(router, cfg)
 }
 
 func getBlock(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
@@ -63,45 +77,45 @@ func getBlock(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
 		return
 	}
 
-	blockNumber, err := strconv.ParseInt(req.BlockNumber, 10, 64)
+	if req.Block == "" {
+		req.Block = req.BlockNumber
+	}
+	method, arg, err := blockArg(req.Block)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	switch req.Include {
+	case "", "hashes", "transactions", "receipts":
+	default:
+		http.Error(w, "Invalid include, expected hashes, transactions or receipts", http.StatusBadRequest)
+		return
+	}
 
-	client, err := ethclient.Dial(cfg.IPCPath)
+	client, err := getClient(cfg)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
-	block, err := client.BlockByNumber(context.Background(), big.NewInt(blockNumber))
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	full := req.Include == "transactions" || req.Include == "receipts"
+	block, err := fetchRPCBlock(ctx, client, method, arg, full)
+	if err == ethereum.NotFound {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	for _, tx := range block.Transactions() {
-		fmt.Println(tx.Hash().Hex())
-	}
-
-	response := BlockResponse{
-		Number:           block.Number().Int64(),
-		Hash:             block.Hash().Hex(),
-		ParentHash:       block.ParentHash().Hex(),
-		Sha3Uncles:       block.UncleHash().Hex(),
-		TransactionsRoot: block.TxHash().Hex(),
-		StateRoot:        block.Root().Hex(),
-		ReceiptsRoot:     block.ReceiptHash().Hex(),
-		Miner:            block.Coinbase().Hex(),
-		Difficulty:       block.Difficulty().Uint64(),
-		TotalDifficulty:  block.Difficulty().Uint64(), // This field may need to be adjusted.
-		GasLimit:         block.GasLimit(),
-		GasUsed:          block.GasUsed(),
-		Timestamp:        block.Time(),
-		ExtraData:        hex.EncodeToString(block.Extra()),
-		MixHash:          block.MixDigest().Hex(),
-		Nonce:            hex.EncodeToString(block.Header().Nonce[:]),
+	response := newBlockResponse(block)
+	if err := includeTransactions(ctx, client, cfg, block, &response, req.Include); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -128,64 +142,52 @@ func getBlockRange(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
 		return
 	}
 
-	client, err := ethclient.Dial(cfg.IPCPath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if endBlockNumber < startBlockNumber {
+		http.Error(w, "endblock is before startblock", http.StatusBadRequest)
+		return
+	}
+	format := req.Format
+	if format == "" {
+		format = "json"
+	}
+	limit := cfg.maxBlockRange()
+	switch format {
+	case "json":
+	case "ndjson", "csv":
+		limit = cfg.maxStreamBlockRange()
+	default:
+		http.Error(w, "Invalid format, expected json, ndjson or csv", http.StatusBadRequest)
+		return
+	}
+	if endBlockNumber-startBlockNumber+1 > limit {
+		http.Error(w, fmt.Sprintf("Block range is limited to %d blocks for %s", limit, format), http.StatusBadRequest)
 		return
 	}
 
-	blockResponses := make(chan BlockResponse, endBlockNumber-startBlockNumber+1)
-	errors := make(chan error, endBlockNumber-startBlockNumber+1)
-	defer close(blockResponses)
-	defer close(errors)
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
-	for i := startBlockNumber; i <= endBlockNumber; i++ {
-		go getBlockAsync(client, big.NewInt(i), blockResponses, errors)
+	if format != "json" {
+		streamBlockRange(w, r, cfg, client, startBlockNumber, endBlockNumber, format, req.Download)
+		return
 	}
 
 	blocks := make([]BlockResponse, 0, endBlockNumber-startBlockNumber+1)
-	for i := startBlockNumber; i <= endBlockNumber; i++ {
-		select {
-		case block := <-blockResponses:
-			blocks = append(blocks, block)
-		case err := <-errors:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	err = fetchBlockRange(r.Context(), cfg, client, startBlockNumber, endBlockNumber, func(block BlockResponse) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	json.NewEncoder(w).Encode(blocks)
 }
 
-func getBlockAsync(client *ethclient.Client, blockNumber *big.Int, blockResponses chan<- BlockResponse, errors chan<- error) {
-	block, err := client.BlockByNumber(context.Background(), blockNumber)
-	if err != nil {
-		errors <- err
-		return
-	}
-
-	blockResponse := BlockResponse{
-		Number:           block.Number().Int64(),
-		Hash:             block.Hash().Hex(),
-		ParentHash:       block.ParentHash().Hex(),
-		Sha3Uncles:       block.UncleHash().Hex(),
-		TransactionsRoot: block.TxHash().Hex(),
-		StateRoot:        block.Root().Hex(),
-		ReceiptsRoot:     block.ReceiptHash().Hex(),
-		Miner:            block.Coinbase().Hex(),
-		Difficulty:       block.Difficulty().Uint64(),
-		TotalDifficulty:  block.Difficulty().Uint64(), // This field may need to be adjusted.
-		GasLimit:         block.GasLimit(),
-		GasUsed:          block.GasUsed(),
-		Timestamp:        block.Time(),
-		ExtraData:        hex.EncodeToString(block.Extra()),
-		MixHash:          block.MixDigest().Hex(),
-		Nonce:            hex.EncodeToString(block.Header().Nonce[:]),
-	}
-
-	blockResponses <- blockResponse
-}
-
 func getTransaction(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
 	decoder := json.NewDecoder(r.Body)
 	var req TransactionRequest
@@ -195,58 +197,52 @@ func getTransaction(w http.ResponseWriter, r *http.Request, cfg EthereumConfig)
 		return
 	}
 
-	client, err := ethclient.Dial(cfg.IPCPath)
+	client, err := getClient(cfg)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
-	txHash := common.HexToHash(req.TxID)
-	tx, isPending, err := client.TransactionByHash(context.Background(), txHash)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
 
-	if isPending {
-		http.Error(w, "Transaction is still pending", http.StatusBadRequest)
+	txHash := common.HexToHash(req.TxID)
+	tx, err := fetchTransaction(ctx, client, txHash)
+	if err == ethereum.NotFound {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
 		return
 	}
-
-	chainID, err := client.NetworkID(context.Background())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	signer := types.NewEIP155Signer(chainID)
-	from, err := types.Sender(signer, tx)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if tx.pending() {
+		response := tx.response(nil, nil)
+		response.Pending = true
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	receipt, err := client.TransactionReceipt(context.Background(), txHash)
+	receipt, err := client.TransactionReceipt(ctx, txHash)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	response := TransactionResponse{
-		BlockHash:        receipt.BlockHash.Hex(),
-		BlockNumber:      receipt.BlockNumber.String(),
-		TransactionIndex: receipt.TransactionIndex,
-		From:             from.Hex(),
-		To:               tx.To().Hex(),
-		Value:            tx.Value().String(),
-		GasPrice:         tx.GasPrice().String(),
-		Gas:              tx.Gas(),
-		Input:            hex.EncodeToString(tx.Data()),
-		Nonce:            tx.Nonce(),
-		Hash:             tx.Hash().Hex(),
+	// Nodes predating London omit effectiveGasPrice; it is then worked out
+	// from the block's base fee.
+	var baseFee *big.Int
+	if receipt.EffectiveGasPrice == nil {
+		header, err := client.HeaderByHash(ctx, receipt.BlockHash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		baseFee = header.BaseFee
 	}
 
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(tx.response(baseFee, receipt))
 }
 
 func getBalance(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
@@ -258,14 +254,23 @@ func getBalance(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
 		return
 	}
 
-	client, err := ethclient.Dial(cfg.IPCPath)
+	client, err := getClient(cfg)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	sel, err := resolveBlockSelector(ctx, client, req.Block, req.Timestamp)
+	if err != nil {
+		http.Error(w, err.Error(), blockSelectorStatus(err))
 		return
 	}
 
 	address := common.HexToAddress(req.Address)
-	balance, err := client.BalanceAt(context.Background(), address, nil)
+	balance, err := balanceAt(ctx, client, address, sel)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -275,11 +280,16 @@ func getBalance(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
 
 	response := BalanceResponse{
 		Balance: etherBalance.Text('f', 18), // 18 decimal places for Ether
+		Symbol:  cfg.symbol(),
+		Block:   sel.ref(),
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
+// getMultiBalance reads many balances, and optionally token balances, in
+// JSON-RPC batches. Addresses that fail are listed in errors instead of
+// failing the whole response.
 func getMultiBalance(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
 	decoder := json.NewDecoder(r.Body)
 	var req MultiBalanceRequest
@@ -289,39 +299,100 @@ func getMultiBalance(w http.ResponseWriter, r *http.Request, cfg EthereumConfig)
 		return
 	}
 
-	client, err := ethclient.Dial(cfg.IPCPath)
+	if len(req.Addresses) > maxMultiBalanceAddresses {
+		http.Error(w, fmt.Sprintf("At most %d addresses can be queried at once", maxMultiBalanceAddresses), http.StatusBadRequest)
+		return
+	}
+
+	client, err := getClient(cfg)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
-	balances := make(map[string]string)
+	selCtx, cancel := callContext(r.Context(), cfg)
+	sel, err := resolveBlockSelector(selCtx, client, req.Block, req.Timestamp)
+	cancel()
+	if err != nil {
+		http.Error(w, err.Error(), blockSelectorStatus(err))
+		return
+	}
 
-	for _, addr := range req.Addresses {
-		address := common.HexToAddress(addr)
-		balance, err := client.BalanceAt(context.Background(), address, nil)
+	response := MultiBalanceResponse{
+		Balances: map[string]string{},
+		Symbol:   cfg.symbol(),
+		Wei:      map[string]string{},
+		Errors:   map[string]string{},
+		Block:    sel.ref(),
+	}
+
+	var tokens []common.Address
+	var metadata []TokenMetadata
+	for _, t := range req.Tokens {
+		token, ok := cfg.resolveToken(t)
+		if !ok {
+			http.Error(w, "Invalid token address "+t, http.StatusBadRequest)
+			return
+		}
+		metaCtx, cancel := callContext(r.Context(), cfg)
+		m, err := getTokenMetadata(metaCtx, client, cfg, token)
+		cancel()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Error reading token %s: %v", token.Hex(), err), http.StatusBadGateway)
 			return
 		}
+		tokens, metadata = append(tokens, token), append(metadata, m)
+	}
+	if len(tokens) > 0 {
+		response.Tokens = map[string][]TokenBalance{}
+	}
 
-		etherBalance := weiToEther(balance)
-		balances[addr] = etherBalance.Text('f', 18)
+	calls := make([]*balanceCall, 0, len(req.Addresses))
+	keys := make([]string, 0, len(req.Addresses))
+	for _, addr := range req.Addresses {
+		if !common.IsHexAddress(addr) {
+			response.Errors[addr] = "invalid address"
+			continue
+		}
+		calls = append(calls, &balanceCall{address: common.HexToAddress(addr)})
+		keys = append(keys, addr)
 	}
 
-	response := MultiBalanceResponse{
-		Balances: balances,
+	batchBalances(r.Context(), client, cfg, sel, calls, tokens)
+
+	for i, call := range calls {
+		addr := keys[i]
+		if call.err != nil {
+			response.Errors[addr] = call.err.Error()
+		} else {
+			balance := (*big.Int)(&call.balance)
+			response.Balances[addr] = weiToEther(balance).Text('f', 18)
+			response.Wei[addr] = balance.String()
+		}
+
+		for j, token := range tokens {
+			tb := TokenBalance{Token: token.Hex(), Symbol: metadata[j].Symbol, Decimals: metadata[j].Decimals}
+			err := call.tokErrs[j]
+			if err == nil {
+				var raw *big.Int
+				if raw, err = tokenBalance(call.tokens[j]); err == nil {
+					tb.Raw = raw.String()
+					tb.Balance = formatUnits(raw, metadata[j].Decimals)
+				}
+			}
+			if err != nil {
+				tb.Error = err.Error()
+			}
+			response.Tokens[addr] = append(response.Tokens[addr], tb)
+		}
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
+// getAllTransactionAddressesHandler submits a scan of the range as a
+// background job; its progress is at /uniqueaddresses/jobs/{id}.
 func getAllTransactionAddressesHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
-	if r.Method != "POST" {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var req AddressRangeRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -329,58 +400,86 @@ func getAllTransactionAddressesHandler(w http.ResponseWriter, r *http.Request, c
 		return
 	}
 
-	start, ok := new(big.Int).SetString(req.Start, 10)
-	if !ok {
+	start, err := strconv.ParseUint(req.Start, 10, 64)
+	if err != nil {
 		http.Error(w, "Invalid start block number", http.StatusBadRequest)
 		return
 	}
-	end, ok := new(big.Int).SetString(req.End, 10)
-	if !ok {
+	end, err := strconv.ParseUint(req.End, 10, 64)
+	if err != nil {
 		http.Error(w, "Invalid end block number", http.StatusBadRequest)
 		return
 	}
-
-	client, err := ethclient.Dial(cfg.IPCPath)
-	if err != nil {
-		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	if end < start {
+		http.Error(w, "End block is before start block", http.StatusBadRequest)
+		return
 	}
 
-	err = getAllTransactionAddresses(client, start, end)
+	job, err := submitAddressScan(cfg, start, end, req.Traces)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching addresses: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error submitting job: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "All addresses processed and set in Redis"})
+	w.Header().Set("Location", r.URL.Path+"/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
 }
 
-func getAllTransactionAddresses(client *ethclient.Client, startBlock, endBlock *big.Int) error {
-	for blockNumber := new(big.Int).Set(startBlock); blockNumber.Cmp(endBlock) <= 0; blockNumber.Add(blockNumber, big.NewInt(1)) {
-		// Print the current block number in the same line
-		fmt.Printf("\rProcessing block: %s", blockNumber.String())
+// getAllTransactionAddresses records every address the job's range touches
+// with its current balance, resuming after the job's checkpoint and calling
+// checkpoint after each finished block. It stops when jobCtx is cancelled or
+// checkpoint fails. When the node has no debug API, internal calls are
+// skipped and the job gets a warning.
+func getAllTransactionAddresses(jobCtx context.Context, client *ethclient.Client, cfg EthereumConfig, job *AddressScanJob, checkpoint func(uint64) error) error {
+	start := job.StartBlock
+	if job.Checkpoint != nil {
+		start = *job.Checkpoint + 1
+	}
+
+	for blockNumber := start; blockNumber <= job.EndBlock; blockNumber++ {
+		callCtx, cancel := callContext(jobCtx, cfg)
+		block, err := fetchBlockByNumber(callCtx, client, new(big.Int).SetUint64(blockNumber), true)
+		cancel()
+		if err != nil {
+			log.Printf("Error fetching block %d: %v", blockNumber, err)
+			return err
+		}
+
+		var traces []txTrace
+		if job.Traces && job.Warning == "" {
+			callCtx, cancel := callContext(jobCtx, cfg)
+			traces, err = traceBlock(callCtx, client, blockNumber)
+			cancel()
+			if traceUnsupported(err) {
+				job.Warning = "traces unavailable, internal calls are not recorded: " + err.Error()
+				log.Printf("Address scan job %s: %s", job.ID, job.Warning)
+			} else if err != nil {
+				return err
+			}
+		}
 
-		block, err := client.BlockByNumber(ctx, blockNumber)
+		addresses, err := blockAddresses(jobCtx, client, cfg, block, traces)
 		if err != nil {
-			log.Printf("\nError fetching block %s: %v", blockNumber, err)
 			return err
 		}
-		for _, tx := range block.Transactions() {
-			to := tx.To()
-			if to != nil {
-				balance, err := client.BalanceAt(ctx, *to, nil)
-				if err != nil {
-					log.Printf("\nError fetching balance for address %s: %v", to.Hex(), err)
-					continue
-				}
 
-				balanceInEth := new(big.Float).Quo(new(big.Float).SetInt(balance), new(big.Float).SetInt(big.NewInt(1e18)))
-				err = rdb.Set(ctx, to.Hex(), balanceInEth.String(), 0).Err()
-				if err != nil {
-					log.Printf("\nError setting balance in Redis for address %s: %v", to.Hex(), err)
-				}
+		for address, roles := range addresses {
+			callCtx, cancel := callContext(jobCtx, cfg)
+			balance, err := client.BalanceAt(callCtx, address, nil)
+			cancel()
+			if err != nil {
+				return err
+			}
+			if err := recordAddress(cfg, address, blockNumber, balance, roles); err != nil {
+				return err
 			}
 		}
+
+		if err := checkpoint(blockNumber); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,132 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testTxHash    = "0x1111111111111111111111111111111111111111111111111111111111111111"
+	testBlockHash = "0x2222222222222222222222222222222222222222222222222222222222222222"
+	testBlobHash  = "0x0133333333333333333333333333333333333333333333333333333333333333"
+	testFrom      = "0x4444444444444444444444444444444444444444"
+	testTo        = "0x5555555555555555555555555555555555555555"
+)
+
+// blobTransaction is a type 3 transaction as a post-Cancun node returns it.
+func blobTransaction(mined bool) map[string]interface{} {
+	tx := map[string]interface{}{
+		"type":                 "0x3",
+		"chainId":              "0x1",
+		"hash":                 testTxHash,
+		"from":                 testFrom,
+		"to":                   testTo,
+		"value":                "0x0",
+		"nonce":                "0x7",
+		"gas":                  "0x5208",
+		"gasPrice":             "0x3b9aca00",
+		"maxFeePerGas":         "0x77359400",
+		"maxPriorityFeePerGas": "0x3b9aca00",
+		"maxFeePerBlobGas":     "0x1",
+		"blobVersionedHashes":  []string{testBlobHash},
+		"accessList":           []interface{}{},
+		"input":                "0x",
+		"v":                    "0x0",
+		"r":                    "0x1",
+		"s":                    "0x1",
+		"yParity":              "0x0",
+		"blockHash":            nil,
+		"blockNumber":          nil,
+		"transactionIndex":     nil,
+	}
+	if mined {
+		tx["blockHash"] = testBlockHash
+		tx["blockNumber"] = "0x10"
+		tx["transactionIndex"] = "0x0"
+	}
+	return tx
+}
+
+func testReceipt() map[string]interface{} {
+	return map[string]interface{}{
+		"type":              "0x3",
+		"transactionHash":   testTxHash,
+		"transactionIndex":  "0x0",
+		"blockHash":         testBlockHash,
+		"blockNumber":       "0x10",
+		"from":              testFrom,
+		"to":                testTo,
+		"status":            "0x1",
+		"gasUsed":           "0x5208",
+		"cumulativeGasUsed": "0x5208",
+		"effectiveGasPrice": "0x3b9aca00",
+		"blobGasUsed":       "0x20000",
+		"blobGasPrice":      "0x1",
+		"contractAddress":   nil,
+		"logs":              []interface{}{},
+		"logsBloom":         "0x" + strings.Repeat("00", 256),
+	}
+}
+
+func requestTransaction(t *testing.T, cfg EthereumConfig) (int, TransactionResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/transaction", strings.NewReader(`{"txid":"`+testTxHash+`"}`))
+	getTransaction(w, r, cfg)
+
+	var response TransactionResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+	}
+	return w.Code, response
+}
+
+func TestGetBlobTransaction(t *testing.T) {
+	stub := newRPCStub(t)
+	stub.reply("eth_getTransactionByHash", blobTransaction(true))
+	stub.reply("eth_getTransactionReceipt", testReceipt())
+
+	status, response := requestTransaction(t, stub.config())
+	if status != http.StatusOK {
+		t.Fatalf("status %d, want %d", status, http.StatusOK)
+	}
+	if response.Type != 3 || response.From != testFrom || response.Nonce != 7 {
+		t.Errorf("got type %d from %s nonce %d", response.Type, response.From, response.Nonce)
+	}
+	if response.MaxFeePerBlobGas == nil || *response.MaxFeePerBlobGas != "1" {
+		t.Errorf("max fee per blob gas %v, want 1", response.MaxFeePerBlobGas)
+	}
+	if len(response.BlobVersionedHashes) != 1 || response.BlobVersionedHashes[0].Hex() != testBlobHash {
+		t.Errorf("blob hashes %v, want [%s]", response.BlobVersionedHashes, testBlobHash)
+	}
+	if response.Pending || response.BlockHash != testBlockHash || response.Status != 1 || response.EffectiveGasPrice != "1000000000" {
+		t.Errorf("got block %s status %d effective gas price %s", response.BlockHash, response.Status, response.EffectiveGasPrice)
+	}
+}
+
+func TestGetPendingTransaction(t *testing.T) {
+	stub := newRPCStub(t)
+	stub.reply("eth_getTransactionByHash", blobTransaction(false))
+
+	status, response := requestTransaction(t, stub.config())
+	if status != http.StatusOK || !response.Pending {
+		t.Errorf("status %d pending %t, want a pending transaction", status, response.Pending)
+	}
+	if stub.called("eth_getTransactionReceipt") != 0 {
+		t.Error("asked for the receipt of a pending transaction")
+	}
+}
+
+func TestGetUnknownTransaction(t *testing.T) {
+	stub := newRPCStub(t)
+	stub.reply("eth_getTransactionByHash", nil)
+
+	if status, _ := requestTransaction(t, stub.config()); status != http.StatusNotFound {
+		t.Errorf("status %d, want %d", status, http.StatusNotFound)
+	}
+}
@@ -1,8 +1,11 @@
 package ethereum
 
 import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
 	"math"
 	"math/big"
+	"strings"
 )
 
 func weiToEther(wei *big.Int) *big.Float {
@@ -10,3 +13,69 @@ func weiToEther(wei *big.Int) *big.Float {
 	ether.SetString(wei.String())
 	return new(big.Float).Quo(ether, big.NewFloat(math.Pow10(18)))
 }
+
+func bigString(n *big.Int) *string {
+	if n == nil {
+		return nil
+	}
+	s := n.String()
+	return &s
+}
+
+func addressHex(address *common.Address) *string {
+	if address == nil {
+		return nil
+	}
+	s := address.Hex()
+	return &s
+}
+
+// formatUnits renders an integer amount of the smallest unit as an exact
+// decimal string, e.g. 1500000 with 6 decimals as "1.5".
+func formatUnits(amount *big.Int, decimals uint8) string {
+	if decimals == 0 {
+		return amount.String()
+	}
+	digits := new(big.Int).Abs(amount).String()
+	if len(digits) <= int(decimals) {
+		digits = strings.Repeat("0", int(decimals)-len(digits)+1) + digits
+	}
+	point := len(digits) - int(decimals)
+	result := digits[:point]
+	if fraction := strings.TrimRight(digits[point:], "0"); fraction != "" {
+		result += "." + fraction
+	}
+	if amount.Sign() < 0 {
+		result = "-" + result
+	}
+	return result
+}
+
+// parseUnits is the inverse of formatUnits, e.g. "1.5" with 6 decimals is
+// 1500000. More fractional digits than decimals is an error.
+func parseUnits(amount string, decimals uint8) (*big.Int, error) {
+	whole, fraction := amount, ""
+	if i := strings.Index(amount, "."); i >= 0 {
+		whole, fraction = amount[:i], amount[i+1:]
+	}
+	if len(fraction) > int(decimals) {
+		return nil, fmt.Errorf("amount %s has more than %d decimals", amount, decimals)
+	}
+	if whole == "" && fraction == "" {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	digits := whole + fraction + strings.Repeat("0", int(decimals)-len(fraction))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("invalid amount %q", amount)
+		}
+	}
+	value, _ := new(big.Int).SetString(digits, 10)
+	return value, nil
+}
+
+// redisKey namespaces the package's Redis keys by network, e.g.
+// "ethereum_tx_0xabc" or "bsc_tx_0xabc".
+func (cfg EthereumConfig) redisKey(parts ...string) string {
+	return cfg.network() + "_" + strings.Join(parts, "_")
+}
@@ -0,0 +1,302 @@
+package ethereum
+
+import (
+	"context"
+	"crypto-api/helper"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Address scans run as background jobs so that they survive the request that
+// started them. A job is stored in Redis with the last block it finished;
+// jobs left queued or running when the server stopped are resumed from
+// there on startup. Each running job holds a Redis lock, renewed with every
+// checkpoint, so that only one instance works on it. Cancelling sets a
+// separate flag that the running instance checks before every block, so
+// that its own status updates cannot overwrite it.
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+
+	jobLockTTL = time.Minute
+)
+
+var (
+	jobsMu          sync.Mutex
+	jobCancels      = map[string]context.CancelFunc{}
+	errJobCancelled = errors.New("job was cancelled")
+	errJobTaken     = errors.New("job is running in another instance")
+)
+
+func registerJobHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/uniqueaddresses/jobs", func(w http.ResponseWriter, r *http.Request) {
+		listAddressScanJobs(w, r, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/uniqueaddresses/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		getAddressScanJob(w, r, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/uniqueaddresses/jobs/{id}/cancel", helper.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		cancelAddressScanJob(w, r, cfg)
+	})).Methods("POST")
+}
+
+func (job *AddressScanJob) done() bool {
+	return job.Status == jobCompleted || job.Status == jobFailed || job.Status == jobCancelled
+}
+
+// progress is the share of the range finished, from 0 to 1.
+func (job *AddressScanJob) progress() float64 {
+	if job.Checkpoint == nil {
+		return 0
+	}
+	return float64(*job.Checkpoint-job.StartBlock+1) / float64(job.EndBlock-job.StartBlock+1)
+}
+
+func saveJob(cfg EthereumConfig, job *AddressScanJob) error {
+	job.UpdatedAt = time.Now().Unix()
+	job.Progress = job.progress()
+	record, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return rdb.HSet(ctx, cfg.redisKey("jobs"), job.ID, record).Err()
+}
+
+func loadJob(cfg EthereumConfig, id string) (*AddressScanJob, error) {
+	record, err := rdb.HGet(ctx, cfg.redisKey("jobs"), id).Result()
+	if err != nil {
+		return nil, err
+	}
+	var job AddressScanJob
+	if err := json.Unmarshal([]byte(record), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func newJobID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// submitAddressScan stores a new job for the range and starts it.
+func submitAddressScan(cfg EthereumConfig, start, end uint64, traces bool) (*AddressScanJob, error) {
+	now := time.Now().Unix()
+	job := &AddressScanJob{
+		ID:         newJobID(),
+		Status:     jobQueued,
+		StartBlock: start,
+		EndBlock:   end,
+		Traces:     traces,
+		CreatedAt:  now,
+	}
+	if err := saveJob(cfg, job); err != nil {
+		return nil, err
+	}
+	go runAddressScan(cfg, job.ID)
+	return job, nil
+}
+
+// ResumeJobs restarts the address scans that were queued or running when the
+// server stopped.
+func ResumeJobs(cfg EthereumConfig) {
+	records, err := rdb.HGetAll(ctx, cfg.redisKey("jobs")).Result()
+	if err != nil {
+		log.Printf("Error loading address scan jobs: %v", err)
+		return
+	}
+	for id, record := range records {
+		var job AddressScanJob
+		if err := json.Unmarshal([]byte(record), &job); err != nil || job.done() {
+			continue
+		}
+		log.Printf("Resuming address scan job %s", id)
+		go runAddressScan(cfg, id)
+	}
+}
+
+func lockJob(cfg EthereumConfig, id, token string) error {
+	ok, err := holdRedisLock(cfg.redisKey("jobs", "lock", id), token, jobLockTTL)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errJobTaken
+	}
+	return nil
+}
+
+func runAddressScan(cfg EthereumConfig, id string) {
+	token := newJobID()
+	if err := lockJob(cfg, id, token); err != nil {
+		log.Printf("Address scan job %s not started: %v", id, err)
+		return
+	}
+	defer unlockScript.Run(ctx, rdb, []string{cfg.redisKey("jobs", "lock", id)}, token)
+
+	job, err := loadJob(cfg, id)
+	if err != nil {
+		log.Printf("Error loading address scan job %s: %v", id, err)
+		return
+	}
+	if job.done() {
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	jobsMu.Lock()
+	jobCancels[id] = cancel
+	jobsMu.Unlock()
+	defer func() {
+		jobsMu.Lock()
+		delete(jobCancels, id)
+		jobsMu.Unlock()
+		cancel()
+	}()
+
+	job.Status, job.Error = jobRunning, ""
+	if err := saveJob(cfg, job); err != nil {
+		log.Printf("Error saving address scan job %s: %v", id, err)
+		return
+	}
+
+	err = jobCancelRequested(cfg, id)
+	if err == nil {
+		var client *ethclient.Client
+		client, err = getClient(cfg)
+		if err == nil {
+			err = getAllTransactionAddresses(jobCtx, client, cfg, job, func(block uint64) error {
+				if err := jobCancelRequested(cfg, id); err != nil {
+					return err
+				}
+				if err := lockJob(cfg, id, token); err != nil {
+					return err
+				}
+				job.Checkpoint = &block
+				return saveJob(cfg, job)
+			})
+		}
+	}
+
+	switch {
+	case err == nil:
+		job.Status = jobCompleted
+	case err == errJobTaken:
+		log.Printf("Address scan job %s: %v", id, err)
+		return
+	case err == errJobCancelled || jobCtx.Err() != nil:
+		job.Status = jobCancelled
+	default:
+		job.Status, job.Error = jobFailed, err.Error()
+	}
+	if err := saveJob(cfg, job); err != nil {
+		log.Printf("Error saving address scan job %s: %v", id, err)
+	}
+	log.Printf("Address scan job %s %s", id, job.Status)
+}
+
+// jobCancelRequested returns errJobCancelled once the job was cancelled from
+// any instance.
+func jobCancelRequested(cfg EthereumConfig, id string) error {
+	n, err := rdb.Exists(ctx, cfg.redisKey("jobs", "cancel", id)).Result()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return errJobCancelled
+	}
+	return nil
+}
+
+func listAddressScanJobs(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	records, err := rdb.HGetAll(ctx, cfg.redisKey("jobs")).Result()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jobs := []AddressScanJob{}
+	for _, record := range records {
+		var job AddressScanJob
+		if err := json.Unmarshal([]byte(record), &job); err == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt > jobs[j].CreatedAt })
+
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func getAddressScanJob(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	job, err := loadJob(cfg, mux.Vars(r)["id"])
+	if err == redis.Nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(job)
+}
+
+// cancelAddressScanJob requests cancellation; the instance running the job
+// stops before its next block and marks it cancelled.
+func cancelAddressScanJob(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	id := mux.Vars(r)["id"]
+	job, err := loadJob(cfg, id)
+	if err == redis.Nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job.done() {
+		http.Error(w, "Job is already "+job.Status, http.StatusConflict)
+		return
+	}
+
+	if err := rdb.Set(ctx, cfg.redisKey("jobs", "cancel", id), 1, 0).Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jobsMu.Lock()
+	if cancel, ok := jobCancels[id]; ok {
+		cancel()
+	}
+	jobsMu.Unlock()
+
+	// Nobody holds the lock of a job left over from a stopped server, so
+	// nobody else would mark it.
+	if n, err := rdb.Exists(ctx, cfg.redisKey("jobs", "lock", id)).Result(); err == nil && n == 0 {
+		job.Status = jobCancelled
+		if err := saveJob(cfg, job); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,424 @@
+package ethereum
+
+import (
+	"context"
+	"crypto-api/helper"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Nodes refuse log queries that match too many logs or span too many blocks,
+// with provider-specific messages. Queries failing with one of these are
+// retried as two halves, at most maxLogSplitDepth times over. Timeouts are
+// not among them: a slow or unreachable node fails the request instead of
+// being asked again for every block.
+var logLimitErrors = []string{
+	"more than",
+	"limit exceeded",
+	"too many",
+	"too large",
+	"block range",
+	"response size",
+}
+
+const (
+	defaultMaxLogRange = 100000
+	maxLogSplitDepth   = 10
+)
+
+// ERC-721 and ERC-1155 events, to decode logs of contracts without a
+// registered ABI. ERC-721 Transfer and Approval share their signatures with
+// ERC-20 and differ only in having the last argument indexed.
+const erc721ABIJSON = `[
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":true,"name":"tokenId","type":"uint256"}],"name":"Transfer","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"owner","type":"address"},{"indexed":true,"name":"approved","type":"address"},{"indexed":true,"name":"tokenId","type":"uint256"}],"name":"Approval","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"owner","type":"address"},{"indexed":true,"name":"operator","type":"address"},{"indexed":false,"name":"approved","type":"bool"}],"name":"ApprovalForAll","type":"event"}
+]`
+
+const erc1155ABIJSON = `[
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"operator","type":"address"},{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"id","type":"uint256"},{"indexed":false,"name":"value","type":"uint256"}],"name":"TransferSingle","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"operator","type":"address"},{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"ids","type":"uint256[]"},{"indexed":false,"name":"values","type":"uint256[]"}],"name":"TransferBatch","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"account","type":"address"},{"indexed":true,"name":"operator","type":"address"},{"indexed":false,"name":"approved","type":"bool"}],"name":"ApprovalForAll","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":false,"name":"value","type":"string"},{"indexed":true,"name":"id","type":"uint256"}],"name":"URI","type":"event"}
+]`
+
+var (
+	erc721ABI  = mustParseABI(erc721ABIJSON)
+	erc1155ABI = mustParseABI(erc1155ABIJSON)
+)
+
+func registerLogHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
+		getLogs(w, r, cfg)
+	}).Methods("POST")
+
+	router.HandleFunc("/abi", helper.RequireAPIKey(registerABIHandler(cfg))).Methods("POST")
+
+	router.HandleFunc("/abi/{address}", func(w http.ResponseWriter, r *http.Request) {
+		getABIHandler(w, r, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/abi/{address}", helper.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		deleteABIHandler(w, r, cfg)
+	})).Methods("DELETE")
+}
+
+func getLogs(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req LogsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var query ethereum.FilterQuery
+	for _, a := range req.Addresses {
+		if !common.IsHexAddress(a) {
+			http.Error(w, "Invalid address "+a, http.StatusBadRequest)
+			return
+		}
+		query.Addresses = append(query.Addresses, common.HexToAddress(a))
+	}
+	for _, position := range req.Topics {
+		var hashes []common.Hash
+		for _, t := range position {
+			topic, err := hexutil.Decode(t)
+			if err != nil || len(topic) != common.HashLength {
+				http.Error(w, "Invalid topic "+t, http.StatusBadRequest)
+				return
+			}
+			hashes = append(hashes, common.BytesToHash(topic))
+		}
+		query.Topics = append(query.Topics, hashes)
+	}
+
+	fromBlock, err := strconv.ParseUint(req.FromBlock, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid fromblock", http.StatusBadRequest)
+		return
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	toBlock, err := resolveToBlock(r.Context(), client, cfg, req.ToBlock)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if toBlock < fromBlock {
+		http.Error(w, "toblock is before fromblock", http.StatusBadRequest)
+		return
+	}
+	if toBlock-fromBlock+1 > uint64(cfg.maxLogRange()) {
+		http.Error(w, fmt.Sprintf("Block range is limited to %d blocks", cfg.maxLogRange()), http.StatusBadRequest)
+		return
+	}
+
+	logs, err := filterLogsSplit(r.Context(), client, cfg, query, fromBlock, toBlock, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	decoded, err := decodeLogs(cfg, logs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := LogsResponse{FromBlock: fromBlock, ToBlock: toBlock, Logs: decoded}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// resolveToBlock turns "latest", or an empty value, into the current head so
+// that the range can be split.
+func resolveToBlock(parent context.Context, client *ethclient.Client, cfg EthereumConfig, toBlock string) (uint64, error) {
+	if toBlock != "" && toBlock != "latest" {
+		n, err := strconv.ParseUint(toBlock, 10, 64)
+		if err != nil {
+			return 0, errors.New("Invalid toblock")
+		}
+		return n, nil
+	}
+	ctx, cancel := callContext(parent, cfg)
+	defer cancel()
+	return client.BlockNumber(ctx)
+}
+
+func (cfg EthereumConfig) maxLogRange() int64 {
+	if cfg.MaxLogRange > 0 {
+		return cfg.MaxLogRange
+	}
+	return defaultMaxLogRange
+}
+
+// filterLogsSplit runs the query over [from, to], halving the range while the
+// node rejects it for returning too much, down to depth maxLogSplitDepth.
+func filterLogsSplit(parent context.Context, client *ethclient.Client, cfg EthereumConfig, query ethereum.FilterQuery, from, to uint64, depth int) ([]types.Log, error) {
+	query.FromBlock = new(big.Int).SetUint64(from)
+	query.ToBlock = new(big.Int).SetUint64(to)
+
+	ctx, cancel := callContext(parent, cfg)
+	logs, err := client.FilterLogs(ctx, query)
+	cancel()
+	if err == nil || from == to || depth >= maxLogSplitDepth || !isLogLimitError(err) || parent.Err() != nil {
+		return logs, err
+	}
+
+	mid := from + (to-from)/2
+	first, err := filterLogsSplit(parent, client, cfg, query, from, mid, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	second, err := filterLogsSplit(parent, client, cfg, query, mid+1, to, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+func isLogLimitError(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, s := range logLimitErrors {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadABI returns the ABI registered for a contract, or nil when there is
+// none.
+func loadABI(cfg EthereumConfig, address common.Address) (*abi.ABI, error) {
+	definition, err := rdb.Get(ctx, cfg.redisKey("abi", address.Hex())).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// decodeLog decodes the event with the contract's ABI, falling back to the
+// ERC-20, ERC-721 and ERC-1155 events so that token transfers and approvals
+// of any contract are readable.
+func decodeLog(l types.Log, contract *abi.ABI) DecodedLog {
+	decoded := DecodedLog{
+		Address:     l.Address.Hex(),
+		Data:        hexutil.Encode(l.Data),
+		BlockNumber: l.BlockNumber,
+		BlockHash:   l.BlockHash.Hex(),
+		TxHash:      l.TxHash.Hex(),
+		TxIndex:     l.TxIndex,
+		LogIndex:    l.Index,
+		Removed:     l.Removed,
+		Topics:      make([]string, len(l.Topics)),
+	}
+	for i, topic := range l.Topics {
+		decoded.Topics[i] = topic.Hex()
+	}
+	if len(l.Topics) == 0 {
+		return decoded
+	}
+
+	candidates := []struct {
+		abi      *abi.ABI
+		standard string
+	}{
+		{contract, ""},
+		{&erc20ABI, "erc20"},
+		{&erc721ABI, "erc721"},
+		{&erc1155ABI, "erc1155"},
+	}
+	for _, candidate := range candidates {
+		if candidate.abi == nil {
+			continue
+		}
+		event, err := candidate.abi.EventByID(l.Topics[0])
+		if err != nil {
+			continue
+		}
+		args, err := unpackEvent(event, l)
+		if err != nil {
+			if decoded.DecodeError == "" {
+				decoded.DecodeError = err.Error()
+			}
+			continue
+		}
+		decoded.Event, decoded.Standard, decoded.Args, decoded.DecodeError = event.Name, candidate.standard, args, ""
+		break
+	}
+	return decoded
+}
+
+// decodeLogs decodes logs, loading the registered ABI of every contract
+// once.
+func decodeLogs(cfg EthereumConfig, logs []types.Log) ([]DecodedLog, error) {
+	abis := map[common.Address]*abi.ABI{}
+	decoded := make([]DecodedLog, 0, len(logs))
+	for _, l := range logs {
+		contract, ok := abis[l.Address]
+		if !ok {
+			var err error
+			contract, err = loadABI(cfg, l.Address)
+			if err != nil {
+				return nil, err
+			}
+			abis[l.Address] = contract
+		}
+		decoded = append(decoded, decodeLog(l, contract))
+	}
+	return decoded, nil
+}
+
+func unpackEvent(event *abi.Event, l types.Log) (map[string]interface{}, error) {
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	if len(l.Topics)-1 != len(indexed) {
+		return nil, fmt.Errorf("%s has %d indexed arguments, log has %d topics", event.Name, len(indexed), len(l.Topics)-1)
+	}
+
+	args := map[string]interface{}{}
+	if err := event.Inputs.NonIndexed().UnpackIntoMap(args, l.Data); err != nil {
+		return nil, err
+	}
+	if err := abi.ParseTopicsIntoMap(args, indexed, l.Topics[1:]); err != nil {
+		return nil, err
+	}
+	for name, value := range args {
+		args[name] = jsonValue(value)
+	}
+	return args, nil
+}
+
+// jsonValue renders ABI values the way the rest of the API does: integers as
+// decimal strings, addresses checksummed and bytes as hex.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *big.Int:
+		return v.String()
+	case common.Address:
+		return v.Hex()
+	case common.Hash:
+		return v.Hex()
+	case []byte:
+		return hexutil.Encode(v)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return hexutil.Encode(b)
+		}
+		fallthrough
+	case reflect.Slice:
+		values := make([]interface{}, rv.Len())
+		for i := range values {
+			values[i] = jsonValue(rv.Index(i).Interface())
+		}
+		return values
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprint(value)
+	}
+	return value
+}
+
+func registerABIHandler(cfg EthereumConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ABIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !common.IsHexAddress(req.Address) {
+			http.Error(w, "Invalid address", http.StatusBadRequest)
+			return
+		}
+		parsed, err := abi.JSON(strings.NewReader(string(req.ABI)))
+		if err != nil {
+			http.Error(w, "Invalid ABI: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		address := common.HexToAddress(req.Address)
+		if err := rdb.Set(ctx, cfg.redisKey("abi", address.Hex()), string(req.ABI), 0).Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		events := []string{}
+		for _, event := range parsed.Events {
+			events = append(events, event.Sig)
+		}
+		json.NewEncoder(w).Encode(struct {
+			Address string   `json:"address"`
+			Events  []string `json:"events"`
+		}{address.Hex(), events})
+	}
+}
+
+func getABIHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	address := mux.Vars(r)["address"]
+	if !common.IsHexAddress(address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	definition, err := rdb.Get(ctx, cfg.redisKey("abi", common.HexToAddress(address).Hex())).Result()
+	if err == redis.Nil {
+		http.Error(w, "No ABI registered for this address", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(definition))
+}
+
+func deleteABIHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	address := mux.Vars(r)["address"]
+	if !common.IsHexAddress(address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	if err := rdb.Del(ctx, cfg.redisKey("abi", common.HexToAddress(address).Hex())).Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
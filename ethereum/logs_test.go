@@ -0,0 +1,103 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"testing"
+)
+
+// serveLogs answers eth_getLogs with one log per block, refusing ranges of
+// more than maxBlocks blocks with refusal.
+func serveLogs(stub *rpcStub, maxBlocks uint64, refusal error) {
+	stub.handle("eth_getLogs", func(params []json.RawMessage) (interface{}, error) {
+		var filter struct {
+			FromBlock string `json:"fromBlock"`
+			ToBlock   string `json:"toBlock"`
+		}
+		if err := json.Unmarshal(params[0], &filter); err != nil {
+			return nil, err
+		}
+		from, _ := hexutil.DecodeUint64(filter.FromBlock)
+		to, _ := hexutil.DecodeUint64(filter.ToBlock)
+		if to-from+1 > maxBlocks {
+			return nil, refusal
+		}
+		logs := []interface{}{}
+		for n := from; n <= to; n++ {
+			logs = append(logs, map[string]interface{}{
+				"address":          testTo,
+				"topics":           []string{transferEventTopic.Hex()},
+				"data":             "0x",
+				"blockNumber":      hexutil.EncodeUint64(n),
+				"blockHash":        chainHash(0xa, n),
+				"transactionHash":  testTxHash,
+				"transactionIndex": "0x0",
+				"logIndex":         "0x0",
+				"removed":          false,
+			})
+		}
+		return logs, nil
+	})
+}
+
+func TestFilterLogsSplit(t *testing.T) {
+	stub := newRPCStub(t)
+	serveLogs(stub, 4, &rpcStubError{Code: -32005, Message: "query returned more than 10000 results"})
+	client, err := getClient(stub.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := filterLogsSplit(context.Background(), client, stub.config(), ethereum.FilterQuery{}, 100, 115, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 16 {
+		t.Fatalf("got %d logs, want 16", len(logs))
+	}
+	for i, l := range logs {
+		if l.BlockNumber != uint64(100+i) {
+			t.Errorf("log %d is of block %d, want %d", i, l.BlockNumber, 100+i)
+		}
+	}
+	// 0-15 fails, both halves fail, their four halves succeed.
+	if calls := stub.called("eth_getLogs"); calls != 7 {
+		t.Errorf("%d queries, want 7", calls)
+	}
+}
+
+func TestFilterLogsSplitOtherErrors(t *testing.T) {
+	stub := newRPCStub(t)
+	serveLogs(stub, 4, &rpcStubError{Code: -32000, Message: "header not found"})
+	client, err := getClient(stub.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := filterLogsSplit(context.Background(), client, stub.config(), ethereum.FilterQuery{}, 100, 115, 0); err == nil {
+		t.Fatal("no error")
+	}
+	if calls := stub.called("eth_getLogs"); calls != 1 {
+		t.Errorf("%d queries, want 1", calls)
+	}
+}
+
+func TestFilterLogsSplitDepth(t *testing.T) {
+	stub := newRPCStub(t)
+	serveLogs(stub, 0, &rpcStubError{Code: -32005, Message: "query returned more than 10000 results"})
+	client, err := getClient(stub.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A node refusing every range gives up after maxLogSplitDepth halvings
+	// of the first half.
+	if _, err := filterLogsSplit(context.Background(), client, stub.config(), ethereum.FilterQuery{}, 0, 1<<20, 0); err == nil {
+		t.Fatal("no error")
+	}
+	if calls := stub.called("eth_getLogs"); calls != maxLogSplitDepth+1 {
+		t.Errorf("%d queries, want %d", calls, maxLogSplitDepth+1)
+	}
+}
@@ -0,0 +1,115 @@
+package ethereum
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"sync"
+)
+
+const (
+	defaultBatchSize         = 100
+	defaultBatchConcurrency  = 4
+	maxMultiBalanceAddresses = 10000
+)
+
+func (cfg EthereumConfig) batchSize() int {
+	if cfg.BatchSize > 0 {
+		return cfg.BatchSize
+	}
+	return defaultBatchSize
+}
+
+func (cfg EthereumConfig) batchConcurrency() int {
+	if cfg.BatchConcurrency > 0 {
+		return cfg.BatchConcurrency
+	}
+	return defaultBatchConcurrency
+}
+
+// balanceCall is one address of a multi-balance lookup: its ether balance
+// and, per requested token, its balanceOf.
+type balanceCall struct {
+	address common.Address
+	balance hexutil.Big
+	tokens  []hexutil.Bytes
+	err     error
+	tokErrs []error
+}
+
+// batchBalances reads the balances of all addresses at the selected block,
+// as JSON-RPC batches of batchSize calls with batchConcurrency batches in
+// flight. Failures are recorded per address, and per token.
+func batchBalances(parent context.Context, client *ethclient.Client, cfg EthereumConfig, sel blockSelector, calls []*balanceCall, tokens []common.Address) {
+	perAddress := 1 + len(tokens)
+	chunk := cfg.batchSize() / perAddress
+	if chunk < 1 {
+		chunk = 1
+	}
+
+	slots := make(chan struct{}, cfg.batchConcurrency())
+	var wg sync.WaitGroup
+	for start := 0; start < len(calls); start += chunk {
+		end := start + chunk
+		if end > len(calls) {
+			end = len(calls)
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(batch []*balanceCall) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			elems := make([]rpc.BatchElem, 0, len(batch)*perAddress)
+			for _, call := range batch {
+				call.tokens = make([]hexutil.Bytes, len(tokens))
+				call.tokErrs = make([]error, len(tokens))
+				elems = append(elems, rpc.BatchElem{
+					Method: "eth_getBalance",
+					Args:   []interface{}{call.address, sel.arg()},
+					Result: &call.balance,
+				})
+				for i, token := range tokens {
+					data, _ := erc20ABI.Pack("balanceOf", call.address)
+					elems = append(elems, rpc.BatchElem{
+						Method: "eth_call",
+						Args:   []interface{}{map[string]interface{}{"to": token, "data": hexutil.Bytes(data)}, sel.arg()},
+						Result: &call.tokens[i],
+					})
+				}
+			}
+
+			ctx, cancel := callContext(parent, cfg)
+			err := client.Client().BatchCallContext(ctx, elems)
+			cancel()
+
+			for j, call := range batch {
+				if err != nil {
+					call.err = err
+					for i := range tokens {
+						call.tokErrs[i] = err
+					}
+					continue
+				}
+				call.err = elems[j*perAddress].Error
+				for i := range tokens {
+					call.tokErrs[i] = elems[j*perAddress+1+i].Error
+				}
+			}
+		}(calls[start:end])
+	}
+	wg.Wait()
+}
+
+// tokenBalance decodes a balanceOf result.
+func tokenBalance(out []byte) (*big.Int, error) {
+	balance := new(big.Int)
+	if err := erc20ABI.UnpackIntoInterface(&balance, "balanceOf", out); err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
@@ -0,0 +1,153 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gorilla/mux"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Every EVM network is served by the same handlers with its own
+// EthereumConfig: the top-level ethereum section under /api/ethereum, and
+// each entry of the evm list under /api/evm/{name}. A network keeps its
+// Redis keys and background workers apart under its name; the ethereum
+// section, which has none, keeps the original "ethereum" prefix.
+
+const defaultNetwork = "ethereum"
+
+var networkNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+func (cfg EthereumConfig) network() string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	return defaultNetwork
+}
+
+func (cfg EthereumConfig) symbol() string {
+	if cfg.Symbol != "" {
+		return cfg.Symbol
+	}
+	return "ETH"
+}
+
+func (cfg EthereumConfig) depositConfirmations() uint64 {
+	switch {
+	case cfg.Deposits.Confirmations > 0:
+		return cfg.Deposits.Confirmations
+	case cfg.Confirmations > 0:
+		return cfg.Confirmations
+	}
+	return defaultDepositConfirmations
+}
+
+func (cfg EthereumConfig) watchConfirmations() uint64 {
+	switch {
+	case cfg.Watch.Confirmations > 0:
+		return cfg.Watch.Confirmations
+	case cfg.Confirmations > 0:
+		return cfg.Confirmations
+	}
+	return defaultWatchConfirmations
+}
+
+// Configured reports whether the section names a node. An unconfigured
+// ethereum section is neither served nor scanned.
+func (cfg EthereumConfig) Configured() bool {
+	return cfg.endpoint() != ""
+}
+
+// Networks checks the evm section of the configuration and returns the
+// networks to serve, skipping those without a usable name or node.
+func Networks(networks []EthereumConfig) []EthereumConfig {
+	seen := map[string]bool{}
+	var valid []EthereumConfig
+	for _, network := range networks {
+		switch {
+		case !networkNamePattern.MatchString(network.Name):
+			log.Printf("Ignoring EVM network %q: names are lowercase letters, digits and dashes", network.Name)
+		case network.Name == defaultNetwork:
+			// It would share the Redis keys of the ethereum section.
+			log.Printf("Ignoring EVM network %s: the name is reserved for the ethereum section", network.Name)
+		case !network.Configured():
+			log.Printf("Ignoring EVM network %s: no ipcpath or endpoint", network.Name)
+		case seen[network.Name]:
+			log.Printf("Ignoring duplicate EVM network %s", network.Name)
+		default:
+			seen[network.Name] = true
+			valid = append(valid, network)
+		}
+	}
+	return valid
+}
+
+// nodeChainID returns the node's chain ID, refusing a node on another chain
+// than the configured one: transactions signed for it would be sent to the
+// wrong network.
+func nodeChainID(ctx context.Context, client *ethclient.Client, cfg EthereumConfig) (*big.Int, error) {
+	id, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ChainID != 0 && id.Cmp(new(big.Int).SetUint64(cfg.ChainID)) != 0 {
+		return nil, fmt.Errorf("node of network %s is on chain %s, expected %d", cfg.network(), id, cfg.ChainID)
+	}
+	return id, nil
+}
+
+// resolveToken accepts a contract address or the symbol of a token in the
+// network's registry.
+func (cfg EthereumConfig) resolveToken(token string) (common.Address, bool) {
+	if common.IsHexAddress(token) {
+		return common.HexToAddress(token), true
+	}
+	for _, t := range cfg.Tokens {
+		if strings.EqualFold(t.Symbol, token) && common.IsHexAddress(t.Address) {
+			return common.HexToAddress(t.Address), true
+		}
+	}
+	return common.Address{}, false
+}
+
+func registerNetworkHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/network", func(w http.ResponseWriter, r *http.Request) {
+		getNetwork(w, r, cfg)
+	}).Methods("GET")
+}
+
+func getNetwork(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	response := NetworkResponse{
+		Name:          cfg.network(),
+		Symbol:        cfg.symbol(),
+		Confirmations: cfg.depositConfirmations(),
+		Tokens:        cfg.Tokens,
+	}
+	if response.Tokens == nil {
+		response.Tokens = []TokenConfig{}
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	id, err := nodeChainID(ctx, client, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	response.ChainID = id.Uint64()
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,520 @@
+package ethereum
+
+import (
+	"context"
+	"crypto-api/helper"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The nonce manager keeps, per sending address, the next nonce to hand out
+// and the hash sent with every nonce in Redis, so several API instances can
+// send from the same address. Handing out a nonce takes a Redis lock that is
+// held until the transaction is broadcast or abandoned. The lock is renewed
+// while held, however long the node takes, and checked to still be ours right
+// before broadcasting: a lock lost to Redis or a stalled renewal must not let
+// two instances send with the same nonce.
+
+const (
+	nonceLockTTL       = 30 * time.Second
+	nonceLockRenew     = nonceLockTTL / 3
+	nonceLockRetry     = 50 * time.Millisecond
+	selfTransferGas    = 21000
+	replacementBumpPct = 125 // nodes require at least 10% more; 25% leaves margin
+)
+
+var errNonceLockLost = errors.New("nonce lock was lost, not broadcasting")
+
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+var extendScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// holdRedisLock takes the lock at key for token, or extends it when token
+// holds it already, and reports whether token holds it. Background workers
+// call it every round to keep their lock; the extension only succeeds while
+// the lock is still theirs.
+func holdRedisLock(key, token string, ttl time.Duration) (bool, error) {
+	ok, err := rdb.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || ok {
+		return ok, err
+	}
+	extended, err := extendScript.Run(ctx, rdb, []string{key}, token, ttl.Milliseconds()).Int()
+	return extended == 1, err
+}
+
+type addressLock struct {
+	cfg     EthereumConfig
+	address common.Address
+	token   string
+	stop    chan struct{}
+	once    sync.Once
+}
+
+type nonceLease struct {
+	lock  *addressLock
+	Nonce uint64
+}
+
+func registerNonceHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/nonce/{address}", func(w http.ResponseWriter, r *http.Request) {
+		getNonceStatusHandler(w, r, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/nonce/resync", helper.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		resyncNonceHandler(w, r, cfg)
+	})).Methods("POST")
+
+	router.HandleFunc("/nonce/fill", helper.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		fillNoncesHandler(w, r, cfg)
+	})).Methods("POST")
+}
+
+// lockAddress waits for the address's nonce lock and keeps renewing it until
+// unlock.
+func lockAddress(ctx context.Context, cfg EthereumConfig, address common.Address) (*addressLock, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	l := &addressLock{cfg: cfg, address: address, token: hex.EncodeToString(buf), stop: make(chan struct{})}
+
+	for {
+		ok, err := rdb.SetNX(ctx, l.key(), l.token, nonceLockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			go l.renew()
+			return l, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(nonceLockRetry):
+		}
+	}
+}
+
+func (l *addressLock) key() string {
+	return l.cfg.redisKey("noncelock", l.address.Hex())
+}
+
+func (l *addressLock) renew() {
+	ticker := time.NewTicker(nonceLockRenew)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.held(); err != nil {
+				log.Printf("Nonce lock of %s: %v", l.address.Hex(), err)
+				return
+			}
+		}
+	}
+}
+
+// held extends the lock, failing when it is no longer ours.
+func (l *addressLock) held() error {
+	extended, err := extendScript.Run(ctx, rdb, []string{l.key()}, l.token, nonceLockTTL.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if extended == 0 {
+		return errNonceLockLost
+	}
+	return nil
+}
+
+func (l *addressLock) unlock() error {
+	l.once.Do(func() { close(l.stop) })
+	return unlockScript.Run(ctx, rdb, []string{l.key()}, l.token).Err()
+}
+
+// acquireNonce locks the address and returns the next nonce to use: the
+// stored one, or the node's pending nonce when transactions were sent
+// without going through the manager. The lease must be committed once the
+// transaction is broadcast, or released.
+func acquireNonce(ctx context.Context, client *ethclient.Client, cfg EthereumConfig, address common.Address) (*nonceLease, error) {
+	lock, err := lockAddress(ctx, cfg, address)
+	if err != nil {
+		return nil, err
+	}
+	lease := &nonceLease{lock: lock}
+
+	stored, err := storedNonce(cfg, address)
+	if err != nil {
+		lease.release()
+		return nil, err
+	}
+	pending, err := client.PendingNonceAt(ctx, address)
+	if err != nil {
+		lease.release()
+		return nil, err
+	}
+
+	lease.Nonce = pending
+	if stored > pending {
+		lease.Nonce = stored
+	}
+	return lease, nil
+}
+
+// commit records the hash sent with the leased nonce, advances the stored
+// nonce and unlocks the address.
+func (l *nonceLease) commit(hash common.Hash) error {
+	defer l.release()
+	cfg, address := l.lock.cfg, l.lock.address
+	if err := rdb.HSet(ctx, cfg.redisKey("nonces", address.Hex()), strconv.FormatUint(l.Nonce, 10), hash.Hex()).Err(); err != nil {
+		return err
+	}
+	stored, err := storedNonce(cfg, address)
+	if err != nil {
+		return err
+	}
+	if l.Nonce+1 > stored {
+		return rdb.Set(ctx, cfg.redisKey("nonce", address.Hex()), l.Nonce+1, 0).Err()
+	}
+	return nil
+}
+
+// held must succeed right before the transaction is broadcast.
+func (l *nonceLease) held() error {
+	return l.lock.held()
+}
+
+func (l *nonceLease) release() {
+	l.lock.unlock()
+}
+
+// storedNonce returns 0 for addresses the manager has not sent from yet.
+func storedNonce(cfg EthereumConfig, address common.Address) (uint64, error) {
+	nonce, err := rdb.Get(ctx, cfg.redisKey("nonce", address.Hex())).Uint64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return nonce, err
+}
+
+func sentNonces(cfg EthereumConfig, address common.Address) (map[uint64]string, error) {
+	entries, err := rdb.HGetAll(ctx, cfg.redisKey("nonces", address.Hex())).Result()
+	if err != nil {
+		return nil, err
+	}
+	sent := make(map[uint64]string, len(entries))
+	for n, hash := range entries {
+		nonce, err := strconv.ParseUint(n, 10, 64)
+		if err != nil {
+			continue
+		}
+		sent[nonce] = hash
+	}
+	return sent, nil
+}
+
+// nonceStatus compares the manager's view of an address with the node's.
+// Nonces between the node's pending nonce and the next stored nonce were
+// handed out but are unknown to the node: they are gaps that hold back every
+// later transaction until filled.
+func nonceStatus(ctx context.Context, client *ethclient.Client, cfg EthereumConfig, address common.Address) (NonceStatus, error) {
+	status := NonceStatus{Address: address.Hex(), Gaps: []uint64{}, Pending: []PendingNonce{}}
+
+	confirmed, err := client.NonceAt(ctx, address, nil)
+	if err != nil {
+		return status, err
+	}
+	pending, err := client.PendingNonceAt(ctx, address)
+	if err != nil {
+		return status, err
+	}
+	next, err := storedNonce(cfg, address)
+	if err != nil {
+		return status, err
+	}
+	sent, err := sentNonces(cfg, address)
+	if err != nil {
+		return status, err
+	}
+
+	status.Confirmed, status.NodePending, status.Next = confirmed, pending, next
+	for n := confirmed; n < pending; n++ {
+		status.Pending = append(status.Pending, PendingNonce{Nonce: n, Hash: sent[n]})
+	}
+	for n := pending; n < next; n++ {
+		status.Gaps = append(status.Gaps, n)
+	}
+	return status, nil
+}
+
+func getNonceStatusHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	address := mux.Vars(r)["address"]
+	if !common.IsHexAddress(address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	status, err := nonceStatus(ctx, client, cfg, common.HexToAddress(address))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
+
+// resyncNonceHandler resets the stored nonce to the node's pending nonce and
+// forgets hashes of confirmed nonces. Gaps are given up: their nonces are
+// handed out again by the next sends.
+func resyncNonceHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req NonceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(req.Address) {
+		http.Error(w, "Invalid address", http.StatusBadRequest)
+		return
+	}
+	address := common.HexToAddress(req.Address)
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	lock, err := lockAddress(ctx, cfg, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer lock.unlock()
+
+	confirmed, err := client.NonceAt(ctx, address, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	pending, err := client.PendingNonceAt(ctx, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	sent, err := sentNonces(cfg, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for n := range sent {
+		if n < confirmed || n >= pending {
+			rdb.HDel(ctx, cfg.redisKey("nonces", address.Hex()), strconv.FormatUint(n, 10))
+		}
+	}
+	if err := rdb.Set(ctx, cfg.redisKey("nonce", address.Hex()), pending, 0).Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status, err := nonceStatus(ctx, client, cfg, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// fillNoncesHandler sends zero-value self-transfers for nonces of the sending
+// address derived from account/index: gaps are filled, and nonces still
+// pending are cancelled by replacing their transaction with higher fees.
+// Without explicit nonces every gap is filled.
+func fillNoncesHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req FillNoncesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cfg.XPrv == "" {
+		http.Error(w, "No signing key is configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	key, err := helper.DerivePrivateKey(cfg.XPrv, signingPath(req.Account, req.Index))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey)
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	lock, err := lockAddress(ctx, cfg, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer lock.unlock()
+
+	status, err := nonceStatus(ctx, client, cfg, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	nonces := req.Nonces
+	if len(nonces) == 0 {
+		nonces = status.Gaps
+	}
+	sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+
+	chainID, err := nodeChainID(ctx, client, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	sent, err := sentNonces(cfg, address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := []FilledNonce{}
+	for _, nonce := range nonces {
+		result := FilledNonce{Nonce: nonce}
+		if nonce < status.Confirmed {
+			result.Error = "nonce is already confirmed"
+			results = append(results, result)
+			continue
+		}
+		if nonce >= status.Next && nonce >= status.NodePending {
+			result.Error = "nonce has not been handed out"
+			results = append(results, result)
+			continue
+		}
+
+		// Every send gets its own timeout: the lock is renewed for as long
+		// as the whole fill takes.
+		sendCtx, cancel := callContext(r.Context(), cfg)
+		var replaced *types.Transaction
+		if hash, ok := sent[nonce]; ok {
+			if tx, isPending, err := client.TransactionByHash(sendCtx, common.HexToHash(hash)); err == nil && isPending {
+				replaced = tx
+				result.Replaced = hash
+			}
+		}
+
+		if err := lock.held(); err != nil {
+			cancel()
+			result.Error = err.Error()
+			results = append(results, result)
+			break
+		}
+		hash, err := sendSelfTransfer(sendCtx, client, chainID, key, nonce, replaced)
+		if err != nil {
+			cancel()
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Hash = hash.Hex()
+		rdb.HSet(sendCtx, cfg.redisKey("nonces", address.Hex()), strconv.FormatUint(nonce, 10), hash.Hex())
+		cancel()
+		if _, err := watchTransaction(cfg, hash, address, nonce); err != nil {
+			log.Printf("Error watching transaction %s: %v", hash.Hex(), err)
+		}
+		results = append(results, result)
+	}
+
+	json.NewEncoder(w).Encode(FillNoncesResponse{Address: address.Hex(), Results: results})
+}
+
+// sendSelfTransfer sends a zero-value transfer to the sender itself with the
+// given nonce. When it replaces a pending transaction its fees are bumped
+// above the replaced ones, as nodes require for replacements.
+func sendSelfTransfer(ctx context.Context, client *ethclient.Client, chainID *big.Int, key *ecdsa.PrivateKey, nonce uint64, replaced *types.Transaction) (common.Hash, error) {
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	tx, err := newTransaction(ctx, client, chainID, address, address, big.NewInt(0), nil, nonce, selfTransferGas, defaultFeeTier)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	if replaced != nil {
+		tip := maxBig(tx.GasTipCap(), bump(replaced.GasTipCap()))
+		feeCap := maxBig(tx.GasFeeCap(), bump(replaced.GasFeeCap()))
+		if tx.Type() == types.LegacyTxType {
+			tx = types.NewTx(&types.LegacyTx{Nonce: nonce, GasPrice: feeCap, Gas: selfTransferGas, To: &address, Value: big.NewInt(0)})
+		} else {
+			tx = types.NewTx(&types.DynamicFeeTx{ChainID: chainID, Nonce: nonce, GasTipCap: tip, GasFeeCap: feeCap, Gas: selfTransferGas, To: &address, Value: big.NewInt(0)})
+		}
+	}
+
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(chainID), key)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := client.SendTransaction(ctx, signed); err != nil {
+		return common.Hash{}, fmt.Errorf("broadcasting nonce %d: %v", nonce, err)
+	}
+	return signed.Hash(), nil
+}
+
+func bump(n *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(n, big.NewInt(replacementBumpPct))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,97 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gorilla/mux"
+	"math/big"
+	"net/http"
+)
+
+func registerReceiptHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/receipt", func(w http.ResponseWriter, r *http.Request) {
+		getReceipt(w, r, cfg)
+	}).Methods("POST")
+}
+
+// getReceipt returns the outcome of a mined transaction. A transaction that
+// reverted is still mined, so callers confirming a withdrawal must check
+// Success, and the decoded logs for the transfer they expect.
+func getReceipt(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req TransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	txHash := common.HexToHash(req.TxID)
+	receipt, err := client.TransactionReceipt(ctx, txHash)
+	if err == ethereum.NotFound {
+		http.Error(w, "Receipt not found, the transaction is unknown or still pending", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Nodes predating London omit effectiveGasPrice; work it out from the
+	// transaction and the block's base fee instead.
+	effectiveGasPrice := receipt.EffectiveGasPrice
+	if effectiveGasPrice == nil {
+		tx, err := fetchTransaction(ctx, client, txHash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		header, err := client.HeaderByHash(ctx, receipt.BlockHash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		effectiveGasPrice = tx.effectiveGasPrice(header.BaseFee)
+	}
+
+	logs := make([]types.Log, len(receipt.Logs))
+	for i, l := range receipt.Logs {
+		logs[i] = *l
+	}
+	decoded, err := decodeLogs(cfg, logs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(receipt.GasUsed), effectiveGasPrice)
+	response := ReceiptResponse{
+		TxHash:            receipt.TxHash.Hex(),
+		BlockHash:         receipt.BlockHash.Hex(),
+		BlockNumber:       receipt.BlockNumber.Uint64(),
+		TransactionIndex:  receipt.TransactionIndex,
+		Type:              receipt.Type,
+		Status:            receipt.Status,
+		Success:           receipt.Status == types.ReceiptStatusSuccessful,
+		GasUsed:           receipt.GasUsed,
+		CumulativeGasUsed: receipt.CumulativeGasUsed,
+		EffectiveGasPrice: effectiveGasPrice.String(),
+		Fee:               fee.String(),
+		FeeEther:          formatUnits(fee, 18),
+		Logs:              decoded,
+	}
+	if receipt.ContractAddress != (common.Address{}) {
+		response.ContractAddress = addressHex(&receipt.ContractAddress)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,320 @@
+package ethereum
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// redisStub is an in-memory Redis speaking enough of the protocol for the
+// package's keys: strings, hashes, sets, sorted sets and MULTI/EXEC. Expiry
+// is ignored and scripts are not supported. useRedisStub points rdb at one
+// for the duration of a test.
+type redisStub struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	sets    map[string]map[string]bool
+	zsets   map[string]map[string]float64
+}
+
+func useRedisStub(t *testing.T) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &redisStub{
+		listener: listener,
+		strings:  map[string]string{},
+		hashes:   map[string]map[string]string{},
+		sets:     map[string]map[string]bool{},
+		zsets:    map[string]map[string]float64{},
+	}
+	go s.serve()
+
+	previous := rdb
+	rdb = redis.NewClient(&redis.Options{Addr: listener.Addr().String()})
+	t.Cleanup(func() {
+		rdb.Close()
+		rdb = previous
+		listener.Close()
+	})
+}
+
+func (s *redisStub) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *redisStub) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch command := strings.ToUpper(args[0]); {
+		case command == "MULTI":
+			inMulti, queued = true, nil
+			reply = "+OK\r\n"
+		case command == "EXEC":
+			replies := make([]string, len(queued))
+			s.mu.Lock()
+			for i, queuedArgs := range queued {
+				replies[i] = s.exec(queuedArgs)
+			}
+			s.mu.Unlock()
+			inMulti = false
+			reply = fmt.Sprintf("*%d\r\n%s", len(replies), strings.Join(replies, ""))
+		case inMulti:
+			queued = append(queued, args)
+			reply = "+QUEUED\r\n"
+		default:
+			s.mu.Lock()
+			reply = s.exec(args)
+			s.mu.Unlock()
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readRESP(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func integer(n int) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+const nilBulk = "$-1\r\n"
+
+func array(values []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(values))
+	for _, v := range values {
+		b.WriteString(bulk(v))
+	}
+	return b.String()
+}
+
+// exec runs one command with s.mu held and returns its encoded reply.
+func (s *redisStub) exec(args []string) string {
+	key := ""
+	if len(args) > 1 {
+		key = args[1]
+	}
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		if value, ok := s.strings[key]; ok {
+			return bulk(value)
+		}
+		return nilBulk
+	case "SET":
+		for _, option := range args[3:] {
+			if strings.ToUpper(option) == "NX" && s.exists(key) {
+				return nilBulk
+			}
+		}
+		s.strings[key] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, k := range args[1:] {
+			if s.exists(k) {
+				deleted++
+			}
+			delete(s.strings, k)
+			delete(s.hashes, k)
+			delete(s.sets, k)
+			delete(s.zsets, k)
+		}
+		return integer(deleted)
+	case "EXPIRE", "PEXPIRE":
+		if s.exists(key) {
+			return integer(1)
+		}
+		return integer(0)
+	case "HGET":
+		if value, ok := s.hashes[key][args[2]]; ok {
+			return bulk(value)
+		}
+		return nilBulk
+	case "HSET":
+		if s.hashes[key] == nil {
+			s.hashes[key] = map[string]string{}
+		}
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := s.hashes[key][args[i]]; !ok {
+				added++
+			}
+			s.hashes[key][args[i]] = args[i+1]
+		}
+		return integer(added)
+	case "HDEL":
+		deleted := 0
+		for _, field := range args[2:] {
+			if _, ok := s.hashes[key][field]; ok {
+				deleted++
+				delete(s.hashes[key], field)
+			}
+		}
+		return integer(deleted)
+	case "HMGET":
+		reply := fmt.Sprintf("*%d\r\n", len(args)-2)
+		for _, field := range args[2:] {
+			if value, ok := s.hashes[key][field]; ok {
+				reply += bulk(value)
+			} else {
+				reply += nilBulk
+			}
+		}
+		return reply
+	case "HLEN":
+		return integer(len(s.hashes[key]))
+	case "HGETALL":
+		var values []string
+		for field, value := range s.hashes[key] {
+			values = append(values, field, value)
+		}
+		return array(values)
+	case "SADD":
+		if s.sets[key] == nil {
+			s.sets[key] = map[string]bool{}
+		}
+		added := 0
+		for _, member := range args[2:] {
+			if !s.sets[key][member] {
+				added++
+				s.sets[key][member] = true
+			}
+		}
+		return integer(added)
+	case "SMEMBERS":
+		var members []string
+		for member := range s.sets[key] {
+			members = append(members, member)
+		}
+		return array(members)
+	case "ZADD":
+		if s.zsets[key] == nil {
+			s.zsets[key] = map[string]float64{}
+		}
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return "-ERR value is not a valid float\r\n"
+			}
+			if _, ok := s.zsets[key][args[i+1]]; !ok {
+				added++
+			}
+			s.zsets[key][args[i+1]] = score
+		}
+		return integer(added)
+	case "ZREM":
+		removed := 0
+		for _, member := range args[2:] {
+			if _, ok := s.zsets[key][member]; ok {
+				removed++
+				delete(s.zsets[key], member)
+			}
+		}
+		return integer(removed)
+	case "ZRANGE", "ZREVRANGE":
+		members := s.zmembers(key)
+		if strings.ToUpper(args[0]) == "ZREVRANGE" {
+			for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+				members[i], members[j] = members[j], members[i]
+			}
+		}
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		if stop < 0 {
+			stop += len(members)
+		}
+		if stop >= len(members) {
+			stop = len(members) - 1
+		}
+		if start > stop {
+			return array(nil)
+		}
+		return array(members[start : stop+1])
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func (s *redisStub) exists(key string) bool {
+	_, isString := s.strings[key]
+	return isString || len(s.hashes[key]) > 0 || len(s.sets[key]) > 0 || len(s.zsets[key]) > 0
+}
+
+// zmembers lists the members of a sorted set by score, then member.
+func (s *redisStub) zmembers(key string) []string {
+	members := make([]string, 0, len(s.zsets[key]))
+	for member := range s.zsets[key] {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := s.zsets[key][members[i]], s.zsets[key][members[j]]
+		if a != b {
+			return a < b
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
@@ -0,0 +1,138 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// rpcStub is a JSON-RPC node for tests. Methods answer with the handlers the
+// test installs; any other method fails as unknown. Batches are supported.
+type rpcStub struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]func(params []json.RawMessage) (interface{}, error)
+	calls    []string
+}
+
+// rpcStubError is returned by handlers to answer with a JSON-RPC error.
+type rpcStubError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcStubError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func newRPCStub(t *testing.T) *rpcStub {
+	t.Helper()
+	s := &rpcStub{handlers: map[string]func([]json.RawMessage) (interface{}, error){}}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// config points an EthereumConfig at the stub.
+func (s *rpcStub) config() EthereumConfig {
+	return EthereumConfig{Endpoint: s.server.URL, RequestTimeout: 5}
+}
+
+func (s *rpcStub) handle(method string, handler func(params []json.RawMessage) (interface{}, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = handler
+}
+
+// reply installs a handler answering every call of method with result.
+func (s *rpcStub) reply(method string, result interface{}) {
+	s.handle(method, func([]json.RawMessage) (interface{}, error) { return result, nil })
+}
+
+// called returns how often method was called.
+func (s *rpcStub) called(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, call := range s.calls {
+		if call == method {
+			count++
+		}
+	}
+	return count
+}
+
+type rpcStubRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type rpcStubResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcStubError   `json:"error,omitempty"`
+}
+
+func (s *rpcStub) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(body) > 0 && body[0] == '[' {
+		var batch []rpcStubRequest
+		if err := json.Unmarshal(body, &batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		responses := make([]rpcStubResponse, len(batch))
+		for i, req := range batch {
+			responses[i] = s.call(req)
+		}
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+
+	var req rpcStubRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(s.call(req))
+}
+
+func (s *rpcStub) call(req rpcStubRequest) rpcStubResponse {
+	s.mu.Lock()
+	s.calls = append(s.calls, req.Method)
+	handler, ok := s.handlers[req.Method]
+	s.mu.Unlock()
+
+	response := rpcStubResponse{Version: "2.0", ID: req.ID}
+	if !ok {
+		response.Error = &rpcStubError{Code: -32601, Message: "the method " + req.Method + " does not exist/is not available"}
+		return response
+	}
+	result, err := handler(req.Params)
+	if err != nil {
+		if rpcErr, ok := err.(*rpcStubError); ok {
+			response.Error = rpcErr
+		} else {
+			response.Error = &rpcStubError{Code: -32000, Message: err.Error()}
+		}
+		return response
+	}
+	if result == nil {
+		// null results, e.g. an unknown transaction, must still be sent.
+		result = json.RawMessage("null")
+	}
+	response.Result = result
+	return response
+}
@@ -0,0 +1,245 @@
+package ethereum
+
+import (
+	"context"
+	"crypto-api/helper"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gorilla/mux"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+func registerSendHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/send", helper.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		sendTransaction(w, r, cfg)
+	})).Methods("POST")
+}
+
+// signingPath is the BIP44 path of an account's receiving key below the
+// coin-level extended private key (m/44'/60').
+func signingPath(account, index uint32) string {
+	return fmt.Sprintf("%d'/0/%d", account, index)
+}
+
+// sendTransaction signs a native or ERC-20 transfer with the key derived for
+// the requested account and index, and broadcasts it.
+func sendTransaction(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req SendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cfg.XPrv == "" {
+		http.Error(w, "No signing key is configured", http.StatusServiceUnavailable)
+		return
+	}
+	if !common.IsHexAddress(req.To) {
+		http.Error(w, "Invalid to address", http.StatusBadRequest)
+		return
+	}
+	token, ok := cfg.resolveToken(req.Token)
+	if req.Token != "" && !ok {
+		http.Error(w, "Invalid token address", http.StatusBadRequest)
+		return
+	}
+	if (req.Value == "") == (req.Amount == "") {
+		http.Error(w, "Exactly one of value or amount is required", http.StatusBadRequest)
+		return
+	}
+	if req.Tier == "" {
+		req.Tier = defaultFeeTier
+	}
+	if !validFeeTier(req.Tier) {
+		http.Error(w, "Tier must be slow, standard or fast", http.StatusBadRequest)
+		return
+	}
+
+	key, err := helper.DerivePrivateKey(cfg.XPrv, signingPath(req.Account, req.Index))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	to := common.HexToAddress(req.To)
+	var decimals uint8 = 18
+	if req.Token != "" {
+		metadata, err := getTokenMetadata(ctx, client, cfg, token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		decimals = metadata.Decimals
+	}
+
+	value, ok := new(big.Int), false
+	if req.Value != "" {
+		value, ok = value.SetString(req.Value, 10)
+		if !ok {
+			http.Error(w, "Invalid value", http.StatusBadRequest)
+			return
+		}
+	} else {
+		value, err = parseUnits(req.Amount, decimals)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if value.Sign() <= 0 {
+		http.Error(w, "Value must be positive", http.StatusBadRequest)
+		return
+	}
+
+	// Token transfers call the contract with no native value attached.
+	callTo, callValue, data := to, value, []byte(nil)
+	if req.Token != "" {
+		data, err = erc20ABI.Pack("transfer", to, value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		callTo, callValue = token, big.NewInt(0)
+	}
+
+	chainID, err := nodeChainID(ctx, client, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	lease, err := acquireNonce(ctx, client, cfg, from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	tx, err := newTransaction(ctx, client, chainID, from, callTo, callValue, data, lease.Nonce, req.GasLimit, req.Tier)
+	if err != nil {
+		lease.release()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(chainID), key)
+	if err != nil {
+		lease.release()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := lease.held(); err != nil {
+		lease.release()
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err := client.SendTransaction(ctx, signed); err != nil {
+		lease.release()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if err := lease.commit(signed.Hash()); err != nil {
+		log.Printf("Error storing nonce %d of %s: %v", lease.Nonce, from.Hex(), err)
+	}
+
+	sent := SentTransaction{
+		Hash:   signed.Hash().Hex(),
+		From:   from.Hex(),
+		To:     to.Hex(),
+		Value:  value.String(),
+		Nonce:  signed.Nonce(),
+		SentAt: time.Now().Unix(),
+	}
+	if req.Token != "" {
+		sent.Token = token.Hex()
+	}
+	if err := storeSentTransaction(cfg, sent); err != nil {
+		// The transaction is already broadcast, so report it anyway.
+		sent.Error = "error storing transaction: " + err.Error()
+	}
+	if _, err := watchTransaction(cfg, signed.Hash(), from, signed.Nonce()); err != nil {
+		log.Printf("Error watching transaction %s: %v", sent.Hash, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sent)
+}
+
+// newTransaction builds an EIP-1559 transaction with the fees /fees quotes for
+// the tier, or a legacy one on chains without a base fee. The gas limit is
+// estimated when gasLimit is zero.
+func newTransaction(ctx context.Context, client *ethclient.Client, chainID *big.Int, from, to common.Address, value *big.Int, data []byte, nonce uint64, gasLimit uint64, tier string) (*types.Transaction, error) {
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if gasLimit == 0 {
+		gasLimit, err = client.EstimateGas(ctx, ethereum.CallMsg{From: from, To: &to, Value: value, Data: data})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if head.BaseFee == nil {
+		gasPrice, err := client.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: gasPrice,
+			Gas:      gasLimit,
+			To:       &to,
+			Value:    value,
+			Data:     data,
+		}), nil
+	}
+
+	quote, err := quoteFees(ctx, client, head)
+	if err != nil {
+		return nil, err
+	}
+	tip, feeCap, ok := quote.tier(tier)
+	if !ok {
+		return nil, fmt.Errorf("unknown fee tier %s", tier)
+	}
+
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Gas:       gasLimit,
+		To:        &to,
+		Value:     value,
+		Data:      data,
+	}), nil
+}
+
+func storeSentTransaction(cfg EthereumConfig, sent SentTransaction) error {
+	record, err := json.Marshal(sent)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, cfg.redisKey("tx", sent.Hash), record, 0).Err()
+}
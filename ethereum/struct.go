@@ -1,39 +1,115 @@
 package ethereum
 
-import "github.com/ethereum/go-ethereum/ethclient"
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
 
 type EthereumConfig struct {
-	IPCPath string `json:"ipcpath"`
+	IPCPath        string        `json:"ipcpath"`
+	Endpoint       string        `json:"endpoint"`
+	RequestTimeout int           `json:"requesttimeout"` // seconds
+	XPrv           string        `json:"xprv"`           // BIP44 coin-level key, m/44'/60'
+	Deposits       DepositConfig `json:"deposits"`
+	Watch          WatchConfig   `json:"watch"`
+
+	Name          string        `json:"name"`          // under /api/evm/{name}; empty for the ethereum section
+	ChainID       uint64        `json:"chainid"`       // checked against the node before signing, 0 to skip
+	Symbol        string        `json:"symbol"`        // native currency, ETH by default
+	Confirmations uint64        `json:"confirmations"` // default for deposits and the watcher
+	Tokens        []TokenConfig `json:"tokens"`        // usable by symbol wherever a token address is expected
+
+	BlockRangeConcurrency int   `json:"blockrangeconcurrency"` // parallel block fetches per request
+	MaxBlockRange         int64 `json:"maxblockrange"`         // blocks per /blockrange request
+	MaxStreamBlockRange   int64 `json:"maxstreamblockrange"`   // same, for ndjson and csv
+	MaxLogRange           int64 `json:"maxlogrange"`           // blocks per /logs request
+	BatchSize             int   `json:"batchsize"`             // calls per JSON-RPC batch
+	BatchConcurrency      int   `json:"batchconcurrency"`      // batches in flight per request
+
+	AllowedOrigins []string `json:"allowedorigins"` // browser origins that may open /ws, e.g. "https://app.example.com"
+}
+
+type DepositConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Accounts      []uint32 `json:"accounts,omitempty"`     // defaults to account 0
+	AddressCount  uint32   `json:"addresscount,omitempty"` // receiving addresses per account
+	Confirmations uint64   `json:"confirmations,omitempty"`
+	StartBlock    uint64   `json:"startblock,omitempty"`   // without a checkpoint; 0 starts at the head
+	Tokens        []string `json:"tokens,omitempty"`       // ERC-20 contracts to watch, empty for all
+	PollInterval  int      `json:"pollinterval,omitempty"` // seconds
+	Traces        bool     `json:"traces,omitempty"`       // internal transfers, needs debug_trace
+}
+
+type TokenConfig struct {
+	Symbol  string `json:"symbol"`
+	Address string `json:"address"`
+}
+
+type NetworkResponse struct {
+	Name          string        `json:"name"`
+	ChainID       uint64        `json:"chainid"`
+	Symbol        string        `json:"symbol"`
+	Confirmations uint64        `json:"confirmations"`
+	Tokens        []TokenConfig `json:"tokens"`
+}
+
+type WatchConfig struct {
+	PollInterval  int    `json:"pollinterval,omitempty"`  // seconds
+	Confirmations uint64 `json:"confirmations,omitempty"` // finality depth on chains without the finalized tag
+	DropAfter     int    `json:"dropafter,omitempty"`     // seconds unseen before a transaction counts as dropped
 }
 
 type BlockRequest struct {
 	BlockNumber string `json:"blocknumber"`
+	Block       string `json:"block"`   // number, hash or tag, instead of blocknumber
+	Include     string `json:"include"` // hashes (default), transactions or receipts
 }
 
 type BlockResponse struct {
-	Number           int64
-	Hash             string
-	ParentHash       string
-	Sha3Uncles       string
-	LogsBloom        interface{}
-	TransactionsRoot string
-	StateRoot        string
-	ReceiptsRoot     string
-	Miner            string
-	Difficulty       uint64
-	TotalDifficulty  uint64
-	Size             interface{}
-	GasLimit         uint64
-	GasUsed          uint64
-	Timestamp        uint64
-	ExtraData        string
-	MixHash          string
-	Nonce            string
+	Number                int64
+	Hash                  string
+	ParentHash            string
+	Sha3Uncles            string
+	LogsBloom             string
+	TransactionsRoot      string
+	StateRoot             string
+	ReceiptsRoot          string
+	Miner                 string
+	Difficulty            string
+	TotalDifficulty       *string // nil when the node does not report it
+	Size                  uint64
+	GasLimit              uint64
+	GasUsed               uint64
+	Timestamp             uint64
+	ExtraData             string
+	MixHash               string
+	Nonce                 string
+	BaseFeePerGas         *string
+	BlobGasUsed           *uint64
+	ExcessBlobGas         *uint64
+	ParentBeaconBlockRoot *string
+	WithdrawalsRoot       *string
+	Withdrawals           []WithdrawalResponse
+	TransactionCount      int
+	TransactionHashes     []string              `json:",omitempty"`
+	Transactions          []TransactionResponse `json:",omitempty"`
+	Receipts              []*types.Receipt      `json:",omitempty"`
+}
+
+type WithdrawalResponse struct {
+	Index     uint64
+	Validator uint64
+	Address   string
+	Amount    uint64 // gwei
 }
 
 type BlockRangeRequest struct {
 	StartBlock string `json:"startblock"`
 	EndBlock   string `json:"endblock"`
+	Format     string `json:"format,omitempty"`   // json (default), ndjson or csv
+	Download   bool   `json:"download,omitempty"` // send as a file attachment
 }
 
 type TransactionRequest struct {
@@ -41,33 +117,342 @@ type TransactionRequest struct {
 }
 
 type TransactionResponse struct {
-	BlockHash        string
-	BlockNumber      string
-	TransactionIndex uint
-	From             string
-	To               string
-	Value            string
-	GasPrice         string
-	Gas              uint64
-	Input            string
-	Nonce            uint64
-	Hash             string
+	BlockHash            string
+	BlockNumber          string
+	TransactionIndex     uint
+	Type                 uint8
+	From                 string
+	To                   *string // nil for contract creations
+	ContractAddress      *string
+	Value                string
+	GasPrice             string
+	MaxFeePerGas         *string
+	MaxPriorityFeePerGas *string
+	MaxFeePerBlobGas     *string
+	EffectiveGasPrice    string
+	Gas                  uint64
+	Input                string
+	Nonce                uint64
+	Hash                 string
+	AccessList           types.AccessList
+	BlobVersionedHashes  []common.Hash
+	Status               uint64
+	Pending              bool // not mined yet: no block, receipt or effective gas price
+}
+
+type ReceiptResponse struct {
+	TxHash            string       `json:"txhash"`
+	BlockHash         string       `json:"blockhash"`
+	BlockNumber       uint64       `json:"blocknumber"`
+	TransactionIndex  uint         `json:"transactionindex"`
+	Type              uint8        `json:"type"`
+	Status            uint64       `json:"status"`
+	Success           bool         `json:"success"`
+	GasUsed           uint64       `json:"gasused"`
+	CumulativeGasUsed uint64       `json:"cumulativegasused"`
+	EffectiveGasPrice string       `json:"effectivegasprice"`
+	Fee               string       `json:"fee"` // wei, gas used times effective gas price
+	FeeEther          string       `json:"feeether"`
+	ContractAddress   *string      `json:"contractaddress,omitempty"`
+	Logs              []DecodedLog `json:"logs"`
 }
 
 type BalanceRequest struct {
-	Address string `json:"address"`
+	Address   string `json:"address"`
+	Block     string `json:"block,omitempty"`     // number, hash, latest, safe, finalized or pending
+	Timestamp int64  `json:"timestamp,omitempty"` // unix time, instead of block
+}
+
+type BlockRef struct {
+	Number    uint64 `json:"number"`
+	Hash      string `json:"hash"`
+	Timestamp uint64 `json:"timestamp"`
 }
 
 type BalanceResponse struct {
-	Balance string `json:"balance"`
+	Balance string    `json:"balance"`
+	Symbol  string    `json:"symbol"` // of the network's native currency
+	Block   *BlockRef `json:"block,omitempty"`
 }
 
 type MultiBalanceRequest struct {
 	Addresses []string `json:"addresses"`
+	Tokens    []string `json:"tokens,omitempty"` // ERC-20 contracts to read too
+	Block     string   `json:"block,omitempty"`
+	Timestamp int64    `json:"timestamp,omitempty"`
 }
 
 type MultiBalanceResponse struct {
-	Balances map[string]string `json:"balances"`
+	Balances map[string]string         `json:"balances"` // ether
+	Symbol   string                    `json:"symbol"`
+	Wei      map[string]string         `json:"wei"`
+	Tokens   map[string][]TokenBalance `json:"tokens,omitempty"`
+	Errors   map[string]string         `json:"errors"`
+	Block    *BlockRef                 `json:"block,omitempty"`
+}
+
+type TokenBalanceRequest struct {
+	Address string   `json:"address"`
+	Tokens  []string `json:"tokens"`
+}
+
+type TokenBalance struct {
+	Token    string `json:"token"`
+	Symbol   string `json:"symbol,omitempty"`
+	Decimals uint8  `json:"decimals"`
+	Raw      string `json:"raw,omitempty"`
+	Balance  string `json:"balance,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type TokenBalanceResponse struct {
+	Address  string         `json:"address"`
+	Balances []TokenBalance `json:"balances"`
+}
+
+type TokenMetadataRequest struct {
+	Token string `json:"token"`
+}
+
+type TokenTransfersRequest struct {
+	Address   string   `json:"address"`
+	Tokens    []string `json:"tokens,omitempty"`
+	FromBlock string   `json:"fromblock"`
+	ToBlock   string   `json:"toblock,omitempty"`
+}
+
+type TokenTransfer struct {
+	Token       string `json:"token"`
+	Symbol      string `json:"symbol,omitempty"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Direction   string `json:"direction"`
+	Raw         string `json:"raw"`
+	Amount      string `json:"amount,omitempty"`
+	BlockNumber uint64 `json:"blocknumber"`
+	TxHash      string `json:"txhash"`
+	LogIndex    uint   `json:"logindex"`
+}
+
+type TokenTransfersResponse struct {
+	Transfers []TokenTransfer `json:"transfers"`
+}
+
+type SendRequest struct {
+	Account  uint32 `json:"account"`
+	Index    uint32 `json:"index"`
+	To       string `json:"to"`
+	Token    string `json:"token,omitempty"`  // ERC-20 contract, empty for ether
+	Value    string `json:"value,omitempty"`  // in the smallest unit
+	Amount   string `json:"amount,omitempty"` // decimal, e.g. "1.5"
+	GasLimit uint64 `json:"gaslimit,omitempty"`
+	Tier     string `json:"tier,omitempty"` // fee tier quoted by /fees, standard by default
+}
+
+type SentTransaction struct {
+	Hash   string `json:"hash"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Token  string `json:"token,omitempty"`
+	Value  string `json:"value"`
+	Nonce  uint64 `json:"nonce"`
+	SentAt int64  `json:"sentat"`
+	Error  string `json:"error,omitempty"`
+}
+
+type TrackedTransaction struct {
+	Hash        string           `json:"hash"`
+	From        string           `json:"from"`
+	Nonce       uint64           `json:"nonce"`
+	Status      string           `json:"status"` // pending, mined, finalized, dropped or replaced
+	BlockNumber uint64           `json:"blocknumber,omitempty"`
+	BlockHash   string           `json:"blockhash,omitempty"`
+	Success     *bool            `json:"success,omitempty"`
+	ReplacedBy  string           `json:"replacedby,omitempty"` // when known
+	SubmittedAt int64            `json:"submittedat"`
+	LastSeen    int64            `json:"lastseen"` // last time the node knew the transaction
+	UpdatedAt   int64            `json:"updatedat"`
+	History     []TxStatusChange `json:"history"`
+}
+
+type TxStatusChange struct {
+	Hash        string `json:"hash"`
+	Status      string `json:"status"`
+	Previous    string `json:"previous,omitempty"`
+	BlockNumber uint64 `json:"blocknumber,omitempty"`
+	ReplacedBy  string `json:"replacedby,omitempty"`
+	At          int64  `json:"at"`
+}
+
+type SubscriptionRequest struct {
+	Action    string   `json:"action"` // subscribe or unsubscribe
+	ID        string   `json:"id"`     // chosen by the client, or generated
+	Type      string   `json:"type"`   // newHeads or activity
+	Addresses []string `json:"addresses"`
+	Tokens    []string `json:"tokens"`
+	FromBlock *uint64  `json:"fromblock"` // resume from this block instead of the next head
+}
+
+type SubscriptionMessage struct {
+	Type         string                `json:"type"` // subscribed, unsubscribed, newHead, activity, checkpoint, reorg or error
+	Subscription string                `json:"subscription,omitempty"`
+	FromBlock    *uint64               `json:"fromblock,omitempty"`
+	Block        *BlockRef             `json:"block,omitempty"`
+	ParentHash   string                `json:"parenthash,omitempty"`
+	Transactions []TransactionResponse `json:"transactions,omitempty"`
+	Logs         []DecodedLog          `json:"logs,omitempty"`
+	Error        string                `json:"error,omitempty"`
+}
+
+type NonceRequest struct {
+	Address string `json:"address"`
+}
+
+type PendingNonce struct {
+	Nonce uint64 `json:"nonce"`
+	Hash  string `json:"hash,omitempty"` // empty when not sent by the manager
+}
+
+type NonceStatus struct {
+	Address     string         `json:"address"`
+	Confirmed   uint64         `json:"confirmed"`   // nonce of the next transaction to be mined
+	NodePending uint64         `json:"nodepending"` // after the node's pending transactions
+	Next        uint64         `json:"next"`        // next nonce the manager hands out
+	Pending     []PendingNonce `json:"pending"`
+	Gaps        []uint64       `json:"gaps"`
+}
+
+type FillNoncesRequest struct {
+	Account uint32   `json:"account"`
+	Index   uint32   `json:"index"`
+	Nonces  []uint64 `json:"nonces,omitempty"`
+}
+
+type FilledNonce struct {
+	Nonce    uint64 `json:"nonce"`
+	Hash     string `json:"hash,omitempty"`
+	Replaced string `json:"replaced,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type FillNoncesResponse struct {
+	Address string        `json:"address"`
+	Results []FilledNonce `json:"results"`
+}
+
+type FeesRequest struct {
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Value    string `json:"value,omitempty"` // in wei
+	Data     string `json:"data,omitempty"`  // hex call data
+	GasLimit uint64 `json:"gaslimit,omitempty"`
+}
+
+type FeeTier struct {
+	Name                 string `json:"name"`
+	MaxPriorityFeePerGas string `json:"maxpriorityfeepergas"`
+	MaxFeePerGas         string `json:"maxfeepergas"`
+	EstimatedCost        string `json:"estimatedcost"` // wei, at the next base fee
+	EstimatedCostEther   string `json:"estimatedcostether"`
+	MaxCost              string `json:"maxcost"` // wei, if the fee cap is reached
+	MaxCostEther         string `json:"maxcostether"`
+}
+
+type FeesResponse struct {
+	BaseFee     string    `json:"basefee,omitempty"`
+	NextBaseFee string    `json:"nextbasefee,omitempty"`
+	Legacy      bool      `json:"legacy"`
+	GasPrice    string    `json:"gasprice,omitempty"` // legacy chains only
+	GasLimit    uint64    `json:"gaslimit"`
+	Tiers       []FeeTier `json:"tiers"`
+}
+
+type LogsRequest struct {
+	Addresses []string   `json:"addresses,omitempty"`
+	Topics    [][]string `json:"topics,omitempty"` // per position, any of; empty matches all
+	FromBlock string     `json:"fromblock"`
+	ToBlock   string     `json:"toblock,omitempty"` // defaults to latest
+}
+
+type DecodedLog struct {
+	Address     string                 `json:"address"`
+	Topics      []string               `json:"topics"`
+	Data        string                 `json:"data"`
+	BlockNumber uint64                 `json:"blocknumber"`
+	BlockHash   string                 `json:"blockhash"`
+	TxHash      string                 `json:"txhash"`
+	TxIndex     uint                   `json:"txindex"`
+	LogIndex    uint                   `json:"logindex"`
+	Removed     bool                   `json:"removed,omitempty"`
+	Event       string                 `json:"event,omitempty"`
+	Standard    string                 `json:"standard,omitempty"` // erc20, erc721 or erc1155 when decoded without a registered ABI
+	Args        map[string]interface{} `json:"args,omitempty"`
+	DecodeError string                 `json:"decodeerror,omitempty"`
+}
+
+type LogsResponse struct {
+	FromBlock uint64       `json:"fromblock"`
+	ToBlock   uint64       `json:"toblock"`
+	Logs      []DecodedLog `json:"logs"`
+}
+
+type ABIRequest struct {
+	Address string          `json:"address"`
+	ABI     json.RawMessage `json:"abi"`
+}
+
+type Deposit struct {
+	ID            string `json:"id"`
+	Address       string `json:"address"`
+	Account       uint32 `json:"account"`
+	Index         uint32 `json:"index"`
+	From          string `json:"from"`
+	Token         string `json:"token,omitempty"` // empty for ether
+	Symbol        string `json:"symbol,omitempty"`
+	Value         string `json:"value"`
+	Amount        string `json:"amount,omitempty"`
+	TxHash        string `json:"txhash"`
+	LogIndex      *uint  `json:"logindex,omitempty"`
+	Internal      bool   `json:"internal,omitempty"` // sent by a contract call
+	BlockNumber   uint64 `json:"blocknumber"`
+	BlockHash     string `json:"blockhash"`
+	Confirmations uint64 `json:"confirmations"`
+	Confirmed     bool   `json:"confirmed"`
+	SeenAt        int64  `json:"seenat"`
+}
+
+type DepositsResponse struct {
+	Head                  uint64    `json:"head"`
+	RequiredConfirmations uint64    `json:"requiredconfirmations"`
+	Deposits              []Deposit `json:"deposits"`
+}
+
+type DepositScannerStatus struct {
+	Enabled    bool    `json:"enabled"`
+	Running    bool    `json:"running"` // holds the scanner lock in this instance
+	Addresses  int     `json:"addresses"`
+	Checkpoint *uint64 `json:"checkpoint"`
+	Head       uint64  `json:"head"`
+	Warning    string  `json:"warning,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type InternalTransfersRequest struct {
+	TxID        string `json:"txid,omitempty"`
+	BlockNumber string `json:"blocknumber,omitempty"`
+}
+
+type InternalTransfer struct {
+	TxHash string `json:"txhash"`
+	Type   string `json:"type"` // call type, e.g. CALL, CREATE or SELFDESTRUCT
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Value  string `json:"value"`  // wei
+	Amount string `json:"amount"` // ether
+}
+
+type InternalTransfersResponse struct {
+	Transfers []InternalTransfer `json:"transfers"`
 }
 
 type BlockRange struct {
@@ -86,8 +471,32 @@ type GetAddressRangeRequest struct {
 }
 
 type AddressRangeRequest struct {
-	Start string `json:"start"`
-	End   string `json:"end"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Traces bool   `json:"traces,omitempty"` // also record internal calls, needs debug_trace
+}
+
+type AddressScanJob struct {
+	ID         string  `json:"id"`
+	Status     string  `json:"status"` // queued, running, completed, failed or cancelled
+	StartBlock uint64  `json:"startblock"`
+	EndBlock   uint64  `json:"endblock"`
+	Traces     bool    `json:"traces"`
+	Checkpoint *uint64 `json:"checkpoint"` // last finished block
+	Progress   float64 `json:"progress"`   // 0 to 1
+	Warning    string  `json:"warning,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	CreatedAt  int64   `json:"createdat"`
+	UpdatedAt  int64   `json:"updatedat"`
+}
+
+type AddressRecord struct {
+	Address   string   `json:"address"`
+	FirstSeen uint64   `json:"firstseen"`
+	LastSeen  uint64   `json:"lastseen"`
+	Roles     []string `json:"roles"`
+	Balance   string   `json:"balance"`   // wei
+	UpdatedAt int64    `json:"updatedat"` // when the balance was read
 }
 
 type AddressBalance struct {
@@ -0,0 +1,580 @@
+package ethereum
+
+import (
+	"context"
+	"crypto-api/helper"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clients of /ws subscribe to new heads or to activity on addresses and
+// token contracts. Every subscription follows the chain block by block from
+// its own cursor, woken by the node's new heads, so a client that reconnects
+// resumes from the block after the last one it received. When a block it was
+// sent is reorged out, the subscription sends a reorg message for it and
+// sends the replacing block next.
+//
+// Activity covers transactions sent from or to the addresses and logs of the
+// token contracts. With both, only logs of the tokens that mention one of the
+// addresses in their topics are sent, e.g. the transfers of a wallet.
+//
+// Like the other endpoints that watch addresses, /ws takes an API key.
+// Browsers cannot set headers on a WebSocket handshake, so a page first posts
+// to /ws/ticket with its key and opens /ws?ticket=... with the single-use
+// ticket it gets back. Pages may only connect from the origins in the
+// allowedorigins setting.
+
+const (
+	headPollInterval       = 4 * time.Second
+	wsPingInterval         = 30 * time.Second
+	wsPongWait             = 60 * time.Second
+	wsWriteWait            = 10 * time.Second
+	maxSubscriptions       = 16
+	maxSubscribedAddresses = 1000
+	maxResumeBlocks        = 1000 // how far back FromBlock may be
+	wsTicketTTL            = 30 * time.Second
+)
+
+// headHub wakes the subscriptions of an endpoint on every new head, relayed
+// from the node's newHeads subscription or, on endpoints without
+// subscriptions such as HTTP, polled.
+type headHub struct {
+	cfg EthereumConfig
+
+	mu          sync.Mutex
+	subscribers map[chan struct{}]bool
+}
+
+var (
+	headHubsMu sync.Mutex
+	headHubs   = map[string]*headHub{}
+)
+
+type subscription struct {
+	id        string
+	kind      string
+	addresses map[common.Address]bool
+	topics    map[common.Hash]bool // the addresses as log topics
+	tokens    map[common.Address]bool
+	next      uint64
+	sent      map[uint64]common.Hash // hashes of recently sent blocks
+}
+
+type wsConn struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+type WebSocketTicket struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expires_in"` // seconds
+}
+
+func registerSubscriptionHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/ws/ticket", helper.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		issueWebSocketTicket(w, r, cfg)
+	})).Methods("POST")
+	router.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ticket := r.URL.Query().Get("ticket")
+		if ticket == "" {
+			helper.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+				serveWebSocket(w, r, cfg)
+			})(w, r)
+			return
+		}
+		ok, err := redeemWebSocketTicket(cfg, ticket)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		serveWebSocket(w, r, cfg)
+	}).Methods("GET")
+}
+
+func issueWebSocketTicket(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ticket := hex.EncodeToString(buf)
+	if err := rdb.Set(ctx, cfg.redisKey("ws", "ticket", ticket), helper.APIClient(r), wsTicketTTL).Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebSocketTicket{Ticket: ticket, ExpiresIn: int(wsTicketTTL / time.Second)})
+}
+
+// redeemWebSocketTicket reports whether the ticket was issued and not used
+// yet, and uses it up.
+func redeemWebSocketTicket(cfg EthereumConfig, ticket string) (bool, error) {
+	key := cfg.redisKey("ws", "ticket", ticket)
+	var get *redis.StringCmd
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return get.Err() == nil, nil
+}
+
+// checkOrigin accepts handshakes without an Origin header, which browsers
+// always send, from the API's own origin and from the configured origins.
+func (cfg EthereumConfig) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range cfg.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func getHeadHub(cfg EthereumConfig) *headHub {
+	headHubsMu.Lock()
+	defer headHubsMu.Unlock()
+	h, ok := headHubs[cfg.endpoint()]
+	if !ok {
+		h = &headHub{cfg: cfg, subscribers: map[chan struct{}]bool{}}
+		headHubs[cfg.endpoint()] = h
+		go h.run()
+	}
+	return h
+}
+
+func (h *headHub) subscribe() chan struct{} {
+	wake := make(chan struct{}, 1)
+	h.mu.Lock()
+	h.subscribers[wake] = true
+	h.mu.Unlock()
+	return wake
+}
+
+func (h *headHub) unsubscribe(wake chan struct{}) {
+	h.mu.Lock()
+	delete(h.subscribers, wake)
+	h.mu.Unlock()
+}
+
+// notify wakes every subscription. One that is still busy with the previous
+// head is not woken twice; it reads the head itself.
+func (h *headHub) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for wake := range h.subscribers {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *headHub) run() {
+	for {
+		if err := h.follow(); err != nil {
+			log.Printf("New heads: %v", err)
+		}
+		time.Sleep(headPollInterval)
+	}
+}
+
+func (h *headHub) follow() error {
+	client, err := getClient(h.cfg)
+	if err != nil {
+		return err
+	}
+
+	headers := make(chan *types.Header, 16)
+	sub, err := client.SubscribeNewHead(context.Background(), headers)
+	if err == rpc.ErrNotificationsUnsupported {
+		return h.poll(client)
+	}
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-headers:
+			h.notify()
+		case err := <-sub.Err():
+			return err
+		}
+	}
+}
+
+func (h *headHub) poll(client *ethclient.Client) error {
+	var last uint64
+	for {
+		ctx, cancel := callContext(context.Background(), h.cfg)
+		head, err := client.BlockNumber(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if head != last {
+			last = head
+			h.notify()
+		}
+		time.Sleep(headPollInterval)
+	}
+}
+
+func serveWebSocket(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	upgrader := websocket.Upgrader{CheckOrigin: cfg.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has answered the request already.
+		return
+	}
+	defer conn.Close()
+
+	connCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &wsConn{conn: conn}
+	hub := getHeadHub(cfg)
+	subs := map[string]context.CancelFunc{}
+
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	go c.ping(connCtx)
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req SubscriptionRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			c.send(SubscriptionMessage{Type: "error", Error: err.Error()})
+			continue
+		}
+
+		switch req.Action {
+		case "subscribe":
+			if len(subs) >= maxSubscriptions {
+				c.send(SubscriptionMessage{Type: "error", Subscription: req.ID, Error: fmt.Sprintf("At most %d subscriptions per connection", maxSubscriptions)})
+				continue
+			}
+			if _, ok := subs[req.ID]; ok && req.ID != "" {
+				c.send(SubscriptionMessage{Type: "error", Subscription: req.ID, Error: "Subscription ID is in use"})
+				continue
+			}
+			s, err := newSubscription(connCtx, cfg, req)
+			if err != nil {
+				c.send(SubscriptionMessage{Type: "error", Subscription: req.ID, Error: err.Error()})
+				continue
+			}
+			subCtx, subCancel := context.WithCancel(connCtx)
+			subs[s.id] = subCancel
+			from := s.next
+			c.send(SubscriptionMessage{Type: "subscribed", Subscription: s.id, FromBlock: &from})
+			go c.follow(subCtx, cfg, hub, s)
+
+		case "unsubscribe":
+			if subCancel, ok := subs[req.ID]; ok {
+				subCancel()
+				delete(subs, req.ID)
+			}
+			c.send(SubscriptionMessage{Type: "unsubscribed", Subscription: req.ID})
+
+		default:
+			c.send(SubscriptionMessage{Type: "error", Subscription: req.ID, Error: "Action must be subscribe or unsubscribe"})
+		}
+	}
+}
+
+func (c *wsConn) send(message SubscriptionMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(message)
+}
+
+func (c *wsConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// newSubscription validates a subscribe request and places the cursor at
+// FromBlock, or after the current head.
+func newSubscription(ctx context.Context, cfg EthereumConfig, req SubscriptionRequest) (*subscription, error) {
+	s := &subscription{
+		id:        req.ID,
+		kind:      req.Type,
+		addresses: map[common.Address]bool{},
+		topics:    map[common.Hash]bool{},
+		tokens:    map[common.Address]bool{},
+		sent:      map[uint64]common.Hash{},
+	}
+	if s.id == "" {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		s.id = hex.EncodeToString(buf)
+	}
+
+	switch req.Type {
+	case "newHeads":
+	case "activity":
+		if len(req.Addresses) == 0 && len(req.Tokens) == 0 {
+			return nil, fmt.Errorf("Activity subscriptions need addresses or tokens")
+		}
+		if len(req.Addresses)+len(req.Tokens) > maxSubscribedAddresses {
+			return nil, fmt.Errorf("At most %d addresses and tokens per subscription", maxSubscribedAddresses)
+		}
+		for _, a := range req.Addresses {
+			if !common.IsHexAddress(a) {
+				return nil, fmt.Errorf("Invalid address %s", a)
+			}
+			address := common.HexToAddress(a)
+			s.addresses[address] = true
+			s.topics[common.BytesToHash(address.Bytes())] = true
+		}
+		for _, t := range req.Tokens {
+			token, ok := cfg.resolveToken(t)
+			if !ok {
+				return nil, fmt.Errorf("Invalid token address %s", t)
+			}
+			s.tokens[token] = true
+		}
+	default:
+		return nil, fmt.Errorf("Type must be newHeads or activity")
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	callCtx, cancel := callContext(ctx, cfg)
+	defer cancel()
+	head, err := client.BlockNumber(callCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.next = head + 1
+	if req.FromBlock != nil {
+		if *req.FromBlock > head+1 {
+			return nil, fmt.Errorf("From block %d is after the head %d", *req.FromBlock, head)
+		}
+		if head+1-*req.FromBlock > maxResumeBlocks {
+			return nil, fmt.Errorf("Cannot resume more than %d blocks back", maxResumeBlocks)
+		}
+		s.next = *req.FromBlock
+	}
+	return s, nil
+}
+
+// follow sends the subscription's blocks up to the head on every new head,
+// until the subscription is cancelled or the connection fails.
+func (c *wsConn) follow(ctx context.Context, cfg EthereumConfig, hub *headHub, s *subscription) {
+	wake := hub.subscribe()
+	defer hub.unsubscribe(wake)
+
+	for {
+		if err := c.catchUp(ctx, cfg, s); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if _, ok := err.(sendError); ok {
+				c.conn.Close()
+				return
+			}
+			// Node errors are retried on the next head.
+			if c.send(SubscriptionMessage{Type: "error", Subscription: s.id, Error: err.Error()}) != nil {
+				c.conn.Close()
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		}
+	}
+}
+
+// sendError tells a failed write to the client apart from node errors.
+type sendError struct{ error }
+
+func (c *wsConn) catchUp(ctx context.Context, cfg EthereumConfig, s *subscription) error {
+	client, err := getClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	callCtx, cancel := callContext(ctx, cfg)
+	head, err := client.BlockNumber(callCtx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for s.next <= head {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		callCtx, cancel := callContext(ctx, cfg)
+		message, block, err := s.blockMessage(callCtx, client, cfg)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		if parent, ok := s.sent[s.next-1]; ok && s.next > 0 && block.ParentHash() != parent {
+			s.next--
+			delete(s.sent, s.next)
+			orphaned := SubscriptionMessage{Type: "reorg", Subscription: s.id, Block: &BlockRef{Number: s.next, Hash: parent.Hex()}}
+			if err := c.send(orphaned); err != nil {
+				return sendError{err}
+			}
+			continue
+		}
+
+		if err := c.send(message); err != nil {
+			return sendError{err}
+		}
+		s.sent[s.next] = block.Hash()
+		delete(s.sent, s.next-reorgDepth)
+		s.next++
+	}
+	return nil
+}
+
+// blockMessage fetches the block at the cursor and builds what the
+// subscription sends for it: the head, the matching activity, or a
+// checkpoint when nothing in the block matches.
+func (s *subscription) blockMessage(ctx context.Context, client *ethclient.Client, cfg EthereumConfig) (SubscriptionMessage, *rpcBlock, error) {
+	full := s.kind == "activity" && len(s.addresses) > 0
+	block, err := fetchBlockByNumber(ctx, client, new(big.Int).SetUint64(s.next), full)
+	if err != nil {
+		return SubscriptionMessage{}, nil, err
+	}
+
+	message := SubscriptionMessage{
+		Type:         "newHead",
+		Subscription: s.id,
+		Block:        &BlockRef{Number: block.NumberU64(), Hash: block.Hash().Hex(), Timestamp: block.header.Time},
+		ParentHash:   block.ParentHash().Hex(),
+	}
+	if s.kind == "newHeads" {
+		return message, block, nil
+	}
+
+	for _, tx := range block.transactions {
+		if s.addresses[tx.From] || (tx.To != nil && s.addresses[*tx.To]) {
+			message.Transactions = append(message.Transactions, tx.response(block.header.BaseFee, nil))
+		}
+	}
+
+	logs, err := s.blockLogs(ctx, client, block.Hash())
+	if err != nil {
+		return SubscriptionMessage{}, nil, err
+	}
+	if len(logs) > 0 {
+		message.Logs, err = decodeLogs(cfg, logs)
+		if err != nil {
+			return SubscriptionMessage{}, nil, err
+		}
+	}
+
+	message.Type = "checkpoint"
+	if len(message.Transactions) > 0 || len(message.Logs) > 0 {
+		message.Type = "activity"
+	}
+	return message, block, nil
+}
+
+// blockLogs leaves the filtering to the node. With addresses, a log matches
+// when one of them is an indexed argument, i.e. any topic after the event
+// signature; the node matches topics by position, so that takes a query for
+// each of the three positions.
+func (s *subscription) blockLogs(ctx context.Context, client *ethclient.Client, hash common.Hash) ([]types.Log, error) {
+	var tokens []common.Address
+	for token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	queries := []ethereum.FilterQuery{{BlockHash: &hash, Addresses: tokens}}
+	if len(s.topics) > 0 {
+		var topics []common.Hash
+		for topic := range s.topics {
+			topics = append(topics, topic)
+		}
+		queries = nil
+		for position := 1; position <= 3; position++ {
+			query := ethereum.FilterQuery{BlockHash: &hash, Addresses: tokens, Topics: make([][]common.Hash, position+1)}
+			query.Topics[position] = topics
+			queries = append(queries, query)
+		}
+	}
+
+	seen := map[uint]bool{}
+	var matched []types.Log
+	for _, query := range queries {
+		logs, err := client.FilterLogs(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range logs {
+			if !seen[l.Index] {
+				seen[l.Index] = true
+				matched = append(matched, l)
+			}
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Index < matched[j].Index })
+	return matched, nil
+}
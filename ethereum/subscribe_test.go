@@ -0,0 +1,30 @@
+package ethereum
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	cfg := EthereumConfig{AllowedOrigins: []string{"https://app.example.com/"}}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://api.example.com", true},
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"https://evil.example.com", false},
+		{"http://app.example.com:8080", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "https://api.example.com/api/ethereum/ws", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if got := cfg.checkOrigin(r); got != test.want {
+			t.Errorf("origin %q: got %t, want %t", test.origin, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// callFrame is a call as reported by geth's callTracer; Calls are the
+// internal calls it made.
+type callFrame struct {
+	Type  string         `json:"type"`
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Value *hexutil.Big   `json:"value"`
+	Error string         `json:"error"`
+	Calls []callFrame    `json:"calls"`
+}
+
+type txTrace struct {
+	TxHash common.Hash `json:"txHash"`
+	Result callFrame   `json:"result"`
+}
+
+// traceBlock returns the call trees of every transaction in a block. Nodes
+// without the debug API return an error for which traceUnsupported is true.
+func traceBlock(ctx context.Context, client *ethclient.Client, number uint64) ([]txTrace, error) {
+	var traces []txTrace
+	err := client.Client().CallContext(ctx, &traces, "debug_traceBlockByNumber", hexutil.EncodeUint64(number), map[string]interface{}{
+		"tracer": "callTracer",
+	})
+	return traces, err
+}
+
+// traceUnsupportedMessages are what nodes and providers answer for a debug
+// method they do not serve, often with a generic code such as -32000 rather
+// than -32601. Only messages that name the method or its namespace count:
+// "state is not available" for a pruned block is a different matter.
+var traceUnsupportedMessages = []string{
+	"does not exist",
+	"is not available",
+	"not supported",
+	"unsupported",
+	"not found",
+	"not allowed",
+	"not whitelisted",
+	"disabled",
+}
+
+// traceUnsupported tells node errors meaning that tracing is not available
+// apart from ones worth retrying.
+func traceUnsupported(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	if rpcErr.ErrorCode() == -32601 {
+		return true
+	}
+	message := strings.ToLower(rpcErr.Error())
+	if !strings.Contains(message, "debug") && !strings.Contains(message, "method") && !strings.Contains(message, "namespace") {
+		return false
+	}
+	for _, m := range traceUnsupportedMessages {
+		if strings.Contains(message, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// traceTransaction returns the call tree of one transaction.
+func traceTransaction(ctx context.Context, client *ethclient.Client, hash common.Hash) (callFrame, error) {
+	var frame callFrame
+	err := client.Client().CallContext(ctx, &frame, "debug_traceTransaction", hash, map[string]interface{}{
+		"tracer": "callTracer",
+	})
+	return frame, err
+}
+
+// walkCalls calls fn for every internal call below frame, depth first.
+// Reverted calls and everything below them are skipped: their effects were
+// undone.
+func walkCalls(frame callFrame, fn func(callFrame)) {
+	if frame.Error != "" {
+		return
+	}
+	for _, call := range frame.Calls {
+		if call.Error != "" {
+			continue
+		}
+		fn(call)
+		walkCalls(call, fn)
+	}
+}
+
+// internalTransfers lists the ether moved by the internal calls of a
+// transaction. Delegate and static calls carry no value of their own.
+func internalTransfers(txHash common.Hash, frame callFrame) []InternalTransfer {
+	transfers := []InternalTransfer{}
+	walkCalls(frame, func(call callFrame) {
+		if call.Value == nil || call.Value.ToInt().Sign() <= 0 {
+			return
+		}
+		if call.Type == "DELEGATECALL" || call.Type == "STATICCALL" {
+			return
+		}
+		value := call.Value.ToInt()
+		transfers = append(transfers, InternalTransfer{
+			TxHash: txHash.Hex(),
+			Type:   call.Type,
+			From:   call.From.Hex(),
+			To:     call.To.Hex(),
+			Value:  value.String(),
+			Amount: formatUnits(value, 18),
+		})
+	})
+	return transfers
+}
+
+func registerTraceHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/internaltransfers", func(w http.ResponseWriter, r *http.Request) {
+		getInternalTransfers(w, r, cfg)
+	}).Methods("POST")
+}
+
+// getInternalTransfers lists the internal ether transfers of a transaction,
+// or of every transaction in a block.
+func getInternalTransfers(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req InternalTransfersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (req.TxID == "") == (req.BlockNumber == "") {
+		http.Error(w, "Exactly one of txid or blocknumber is required", http.StatusBadRequest)
+		return
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	response := InternalTransfersResponse{Transfers: []InternalTransfer{}}
+	if req.TxID != "" {
+		hash := common.HexToHash(req.TxID)
+		var frame callFrame
+		frame, err = traceTransaction(ctx, client, hash)
+		if err == nil {
+			response.Transfers = internalTransfers(hash, frame)
+		}
+	} else {
+		var number uint64
+		number, err = strconv.ParseUint(req.BlockNumber, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid blocknumber", http.StatusBadRequest)
+			return
+		}
+		var traces []txTrace
+		traces, err = traceBlock(ctx, client, number)
+		for _, trace := range traces {
+			response.Transfers = append(response.Transfers, internalTransfers(trace.TxHash, trace.Result)...)
+		}
+	}
+	if traceUnsupported(err) {
+		http.Error(w, "The Ethereum node does not provide the debug API: "+err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
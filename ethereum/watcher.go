@@ -0,0 +1,375 @@
+package ethereum
+
+import (
+	"context"
+	"crypto-api/helper"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The transaction watcher follows the transactions we send, and any hash
+// added through /watch, from pending to mined to finalized. A transaction
+// the node no longer knows is replaced when its nonce was used by another
+// hash, and dropped when it stays unseen for DropAfter seconds. Every status
+// change is appended to the transaction's history and published as a
+// TxStatusChange on the Redis channel redisKey("watch", "events").
+
+const (
+	defaultWatchPollInterval  = 12 * time.Second
+	defaultWatchConfirmations = 64
+	defaultWatchDropAfter     = 30 * time.Minute
+	watcherLockTTL            = time.Minute
+)
+
+const (
+	txPending   = "pending"
+	txMined     = "mined"
+	txFinalized = "finalized"
+	txDropped   = "dropped"
+	txReplaced  = "replaced"
+)
+
+var (
+	txWatchersMu sync.Mutex
+	txWatchers   = map[string]bool{}
+)
+
+func (c WatchConfig) pollInterval() time.Duration {
+	if c.PollInterval > 0 {
+		return time.Duration(c.PollInterval) * time.Second
+	}
+	return defaultWatchPollInterval
+}
+
+func (c WatchConfig) dropAfter() time.Duration {
+	if c.DropAfter > 0 {
+		return time.Duration(c.DropAfter) * time.Second
+	}
+	return defaultWatchDropAfter
+}
+
+func (t *TrackedTransaction) done() bool {
+	return t.Status == txFinalized || t.Status == txDropped || t.Status == txReplaced
+}
+
+func registerWatchHandlers(router *mux.Router, cfg EthereumConfig) {
+	router.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+		listWatchedHandler(w, r, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/watch", helper.RequireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		watchHandler(w, r, cfg)
+	})).Methods("POST")
+
+	router.HandleFunc("/watch/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		getWatchedHandler(w, r, cfg)
+	}).Methods("GET")
+}
+
+// StartTxWatcher starts following watched transactions in the background.
+// Only one instance polls at a time; the others wait for its Redis lock to
+// expire.
+func StartTxWatcher(cfg EthereumConfig) {
+	txWatchersMu.Lock()
+	defer txWatchersMu.Unlock()
+	if txWatchers[cfg.network()] {
+		return
+	}
+	txWatchers[cfg.network()] = true
+	go runTxWatcher(cfg)
+}
+
+func runTxWatcher(cfg EthereumConfig) {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+
+	for {
+		locked, err := holdWatcherLock(cfg, token)
+		if err == nil && locked {
+			err = pollWatched(cfg)
+		}
+		if err != nil {
+			log.Printf("Transaction watcher: %v", err)
+		}
+		time.Sleep(cfg.Watch.pollInterval())
+	}
+}
+
+func holdWatcherLock(cfg EthereumConfig, token string) (bool, error) {
+	return holdRedisLock(cfg.redisKey("watch", "lock"), token, watcherLockTTL)
+}
+
+// watchTransaction starts following a transaction as pending. Watching a
+// hash again restarts it, e.g. after a dropped transaction was rebroadcast.
+func watchTransaction(cfg EthereumConfig, hash common.Hash, from common.Address, nonce uint64) (*TrackedTransaction, error) {
+	now := time.Now().Unix()
+	tracked := &TrackedTransaction{
+		Hash:        hash.Hex(),
+		From:        from.Hex(),
+		Nonce:       nonce,
+		SubmittedAt: now,
+		LastSeen:    now,
+	}
+	if err := setTxStatus(cfg, tracked, txPending); err != nil {
+		return nil, err
+	}
+	return tracked, rdb.SAdd(ctx, cfg.redisKey("watch", "active"), tracked.Hash).Err()
+}
+
+func loadTracked(cfg EthereumConfig, hash string) (*TrackedTransaction, error) {
+	record, err := rdb.HGet(ctx, cfg.redisKey("watch"), hash).Result()
+	if err != nil {
+		return nil, err
+	}
+	var tracked TrackedTransaction
+	if err := json.Unmarshal([]byte(record), &tracked); err != nil {
+		return nil, err
+	}
+	return &tracked, nil
+}
+
+func saveTracked(cfg EthereumConfig, tracked *TrackedTransaction) error {
+	tracked.UpdatedAt = time.Now().Unix()
+	record, err := json.Marshal(tracked)
+	if err != nil {
+		return err
+	}
+	return rdb.HSet(ctx, cfg.redisKey("watch"), tracked.Hash, record).Err()
+}
+
+// setTxStatus records a status change, saves the transaction and publishes
+// the change.
+func setTxStatus(cfg EthereumConfig, tracked *TrackedTransaction, status string) error {
+	change := TxStatusChange{
+		Hash:        tracked.Hash,
+		Status:      status,
+		Previous:    tracked.Status,
+		BlockNumber: tracked.BlockNumber,
+		ReplacedBy:  tracked.ReplacedBy,
+		At:          time.Now().Unix(),
+	}
+	tracked.Status = status
+	tracked.History = append(tracked.History, change)
+	if err := saveTracked(cfg, tracked); err != nil {
+		return err
+	}
+
+	event, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return rdb.Publish(ctx, cfg.redisKey("watch", "events"), event).Err()
+}
+
+func pollWatched(cfg EthereumConfig) error {
+	hashes, err := rdb.SMembers(ctx, cfg.redisKey("watch", "active")).Result()
+	if err != nil || len(hashes) == 0 {
+		return err
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		tracked, err := loadTracked(cfg, hash)
+		if err == redis.Nil {
+			rdb.SRem(ctx, cfg.redisKey("watch", "active"), hash)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		callCtx, cancel := callContext(context.Background(), cfg)
+		err = checkTracked(callCtx, client, cfg, tracked)
+		cancel()
+		if err != nil {
+			log.Printf("Transaction watcher: %s: %v", hash, err)
+			continue
+		}
+		if tracked.done() {
+			rdb.SRem(ctx, cfg.redisKey("watch", "active"), hash)
+		}
+	}
+	return nil
+}
+
+// checkTracked asks the node about a watched transaction and records any
+// status change.
+func checkTracked(ctx context.Context, client *ethclient.Client, cfg EthereumConfig, tracked *TrackedTransaction) error {
+	hash := common.HexToHash(tracked.Hash)
+	from := common.HexToAddress(tracked.From)
+	status := tracked.Status
+
+	receipt, err := client.TransactionReceipt(ctx, hash)
+	switch {
+	case err == nil:
+		finalized, err := finalizedBlock(ctx, client, cfg)
+		if err != nil {
+			return err
+		}
+		success := receipt.Status == types.ReceiptStatusSuccessful
+		tracked.BlockNumber, tracked.BlockHash, tracked.Success = receipt.BlockNumber.Uint64(), receipt.BlockHash.Hex(), &success
+		tracked.LastSeen = time.Now().Unix()
+		status = txMined
+		if tracked.BlockNumber <= finalized {
+			status = txFinalized
+		}
+
+	case err == ethereum.NotFound:
+		tx, err := fetchTransaction(ctx, client, hash)
+		if err == nil && !tx.pending() {
+			// Mined after the receipt was asked for; next poll.
+			return nil
+		}
+		// Not mined yet, or back from a block that was reorged out.
+		tracked.BlockNumber, tracked.BlockHash, tracked.Success = 0, "", nil
+		if err == nil {
+			tracked.LastSeen = time.Now().Unix()
+			status = txPending
+			break
+		}
+		if err != ethereum.NotFound {
+			return err
+		}
+
+		// The nonce manager records the hash sent last with every nonce, so
+		// a different hash there is the replacement.
+		sent, err := sentNonces(cfg, from)
+		if err != nil {
+			return err
+		}
+		if replacement, ok := sent[tracked.Nonce]; ok && replacement != tracked.Hash {
+			tracked.ReplacedBy = replacement
+			status = txReplaced
+			break
+		}
+		confirmed, err := client.NonceAt(ctx, from, nil)
+		if err != nil {
+			return err
+		}
+		if confirmed > tracked.Nonce {
+			status = txReplaced
+			break
+		}
+		if time.Since(time.Unix(tracked.LastSeen, 0)) > cfg.Watch.dropAfter() {
+			status = txDropped
+		}
+
+	default:
+		return err
+	}
+
+	if status != tracked.Status {
+		return setTxStatus(cfg, tracked, status)
+	}
+	return saveTracked(cfg, tracked)
+}
+
+// finalizedBlock returns the node's finalized block, or the block the
+// configured number of confirmations below the head on chains without the
+// finalized tag.
+func finalizedBlock(ctx context.Context, client *ethclient.Client, cfg EthereumConfig) (uint64, error) {
+	if block, err := fetchRPCBlock(ctx, client, "eth_getBlockByNumber", "finalized", false); err == nil {
+		return block.NumberU64(), nil
+	}
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if head < cfg.watchConfirmations() {
+		return 0, nil
+	}
+	return head - cfg.watchConfirmations(), nil
+}
+
+func watchHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	var req TransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := getClient(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := callContext(r.Context(), cfg)
+	defer cancel()
+
+	tx, err := fetchTransaction(ctx, client, common.HexToHash(req.TxID))
+	if err == ethereum.NotFound {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	tracked, err := watchTransaction(cfg, tx.Hash, tx.From, uint64(tx.Nonce))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Report a mined transaction as such right away.
+	if err := checkTracked(ctx, client, cfg, tracked); err != nil {
+		log.Printf("Error checking watched transaction %s: %v", tracked.Hash, err)
+	}
+
+	json.NewEncoder(w).Encode(tracked)
+}
+
+func getWatchedHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	tracked, err := loadTracked(cfg, common.HexToHash(mux.Vars(r)["hash"]).Hex())
+	if err == redis.Nil {
+		http.Error(w, "Transaction is not watched", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tracked)
+}
+
+// listWatchedHandler lists the transactions still being followed.
+func listWatchedHandler(w http.ResponseWriter, r *http.Request, cfg EthereumConfig) {
+	hashes, err := rdb.SMembers(ctx, cfg.redisKey("watch", "active")).Result()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	watched := []*TrackedTransaction{}
+	for _, hash := range hashes {
+		tracked, err := loadTracked(cfg, hash)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		watched = append(watched, tracked)
+	}
+
+	json.NewEncoder(w).Encode(watched)
+}
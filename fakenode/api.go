@@ -0,0 +1,86 @@
+package fakenode
+
+import (
+	"bytes"
+	"crypto-api/helper"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// APIKey is accepted by the API servers of NewAPIServer.
+const APIKey = "test-key"
+
+// Response is a JSON-RPC response as the coin handlers relay it.
+type Response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// NewAPIServer starts a fake node for the coin and an API server in front of
+// it, both closed at the end of the test. register adds the coin's handlers,
+// configured with the node's address and credentials:
+//
+//	node, server := fakenode.NewAPIServer(t, fakenode.Bitcoin, func(router *mux.Router, node *fakenode.Node) {
+//		bitcoin.RegisterHandlers(router, bitcoin.CoinConfig{
+//			RPCIP: node.Host(), RPCPort: node.Port(), RPCUser: node.User, RPCPassword: node.Password,
+//		})
+//	})
+func NewAPIServer(t testing.TB, coin Coin, register func(router *mux.Router, node *Node)) (*Node, *httptest.Server) {
+	t.Helper()
+	node := New(coin)
+	t.Cleanup(node.Close)
+	helper.SetAPIKeys(map[string]string{APIKey: "tester"})
+
+	router := mux.NewRouter()
+	register(router, node)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return node, server
+}
+
+// Post sends body as JSON to the API, with apiKey unless it is empty, and
+// returns the status and body of the response.
+func Post(t testing.TB, server *httptest.Server, path, apiKey string, body interface{}) (int, []byte) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", server.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	response, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, response
+}
+
+// DecodeResponse decodes the result of a relayed JSON-RPC response into
+// result, which may be nil, and returns its error.
+func DecodeResponse(t testing.TB, response []byte, result interface{}) *RPCError {
+	t.Helper()
+	var rpcResponse Response
+	if err := json.Unmarshal(response, &rpcResponse); err != nil {
+		t.Fatalf("decoding %s: %v", response, err)
+	}
+	if rpcResponse.Error == nil && result != nil {
+		if err := json.Unmarshal(rpcResponse.Result, result); err != nil {
+			t.Fatalf("decoding result %s: %v", rpcResponse.Result, err)
+		}
+	}
+	return rpcResponse.Error
+}
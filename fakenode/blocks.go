@@ -0,0 +1,85 @@
+package fakenode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+type Block struct {
+	Hash              string   `json:"hash"`
+	Height            int      `json:"height"`
+	Time              int64    `json:"time"`
+	PreviousBlockHash string   `json:"previousblockhash,omitempty"`
+	Tx                []string `json:"tx"`
+}
+
+// MineBlocks appends count blocks to the chain, confirming every wallet
+// transaction once more per block, and returns the new blocks.
+func (n *Node) MineBlocks(count int) []Block {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.mineLocked(count)
+}
+
+// BlockCount returns the height of the chain tip.
+func (n *Node) BlockCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.blocks) - 1
+}
+
+func (n *Node) mineLocked(count int) []Block {
+	mined := make([]Block, 0, count)
+	for i := 0; i < count; i++ {
+		height := len(n.blocks)
+		block := &Block{
+			Height: height,
+			Time:   time.Now().Unix(),
+			Tx:     []string{},
+		}
+		if height > 0 {
+			block.PreviousBlockHash = n.blocks[height-1].Hash
+		}
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%s", n.Coin, height, block.PreviousBlockHash)))
+		block.Hash = hex.EncodeToString(sum[:])
+
+		for _, w := range n.wallets {
+			for j := range w.utxos {
+				if w.utxos[j].Confirmations == 0 && height > 0 {
+					block.Tx = appendUnique(block.Tx, w.utxos[j].TxID)
+				}
+				w.utxos[j].Confirmations++
+			}
+			for j := range w.transactions {
+				if w.transactions[j].Confirmations == 0 && height > 0 {
+					block.Tx = appendUnique(block.Tx, w.transactions[j].TxID)
+				}
+				w.transactions[j].Confirmations++
+			}
+		}
+
+		n.blocks = append(n.blocks, block)
+		mined = append(mined, *block)
+	}
+	return mined
+}
+
+func (n *Node) blockByHashLocked(hash string) *Block {
+	for _, b := range n.blocks {
+		if b.Hash == hash {
+			return b
+		}
+	}
+	return nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
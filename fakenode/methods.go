@@ -0,0 +1,663 @@
+package fakenode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const ErrMethodDeprecated = -32
+
+// builtin returns the node's own implementation of method. Label and
+// descriptor RPCs only exist on bitcoin and litecoin, while dogecoin still
+// speaks the account API.
+func (n *Node) builtin(method string) (HandlerFunc, bool) {
+	common := map[string]HandlerFunc{
+		"getblockcount":        n.getBlockCount,
+		"getbestblockhash":     n.getBestBlockHash,
+		"getblockhash":         n.getBlockHash,
+		"getblock":             n.getBlock,
+		"getblockchaininfo":    n.getBlockchainInfo,
+		"getnetworkinfo":       n.getNetworkInfo,
+		"getchaintips":         n.getChainTips,
+		"getnewaddress":        n.getNewAddress,
+		"getbalance":           n.getBalance,
+		"listunspent":          n.listUnspent,
+		"gettransaction":       n.getTransaction,
+		"getreceivedbyaddress": n.getReceivedByAddress,
+		"listtransactions":     n.listTransactions,
+		"sendtoaddress":        n.sendToAddress,
+	}
+	if h, ok := common[method]; ok {
+		return h, true
+	}
+
+	var specific map[string]HandlerFunc
+	if n.Coin == Dogecoin {
+		specific = map[string]HandlerFunc{
+			"listaccounts":          n.listAccounts,
+			"getaddressesbyaccount": n.getAddressesByAccount,
+			"setaccount":            n.setAccount,
+			"getaccount":            n.getAccount,
+			"validateaddress":       n.validateAddress,
+			"importmulti":           n.importMulti,
+		}
+	} else {
+		specific = map[string]HandlerFunc{
+			"listlabels":          n.listLabels,
+			"getaddressesbylabel": n.getAddressesByLabel,
+			"setlabel":            n.setLabel,
+			"getaddressinfo":      n.getAddressInfo,
+			"getdescriptorinfo":   n.getDescriptorInfo,
+			"createwallet":        n.createWallet,
+			"importdescriptors":   n.importDescriptors,
+		}
+	}
+	h, ok := specific[method]
+	return h, ok
+}
+
+// param decodes the i-th positional parameter into v and reports whether it
+// was given. A JSON null counts as omitted, as it does for the daemons.
+func param(params []json.RawMessage, i int, v interface{}) (bool, error) {
+	if i >= len(params) || string(params[i]) == "null" {
+		return false, nil
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return false, &RPCError{Code: ErrTypeError, Message: fmt.Sprintf("Expected type for parameter %d: %v", i+1, err)}
+	}
+	return true, nil
+}
+
+func maxParams(params []json.RawMessage, max int, usage string) error {
+	if len(params) > max {
+		return &RPCError{Code: ErrMisc, Message: usage}
+	}
+	return nil
+}
+
+func invalidAddress(address string) error {
+	return &RPCError{Code: ErrInvalidAddress, Message: "Invalid address: " + address}
+}
+
+func (n *Node) tipLocked() *Block {
+	return n.blocks[len(n.blocks)-1]
+}
+
+func (n *Node) getBlockCount(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	return n.tipLocked().Height, nil
+}
+
+func (n *Node) getBestBlockHash(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	return n.tipLocked().Hash, nil
+}
+
+func (n *Node) getBlockHash(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var height int
+	if ok, err := param(params, 0, &height); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "getblockhash height"}
+	}
+	if height < 0 || height >= len(n.blocks) {
+		return nil, &RPCError{Code: ErrInvalidParameter, Message: "Block height out of range"}
+	}
+	return n.blocks[height].Hash, nil
+}
+
+func (n *Node) getBlock(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var hash string
+	if ok, err := param(params, 0, &hash); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "getblock \"blockhash\" ( verbosity )"}
+	}
+	b := n.blockByHashLocked(hash)
+	if b == nil {
+		return nil, &RPCError{Code: ErrInvalidAddress, Message: "Block not found"}
+	}
+	return struct {
+		Block
+		Confirmations int `json:"confirmations"`
+	}{*b, n.tipLocked().Height - b.Height + 1}, nil
+}
+
+func (n *Node) getBlockchainInfo(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	tip := n.tipLocked()
+	return map[string]interface{}{
+		"chain":         "regtest",
+		"blocks":        tip.Height,
+		"headers":       tip.Height,
+		"bestblockhash": tip.Hash,
+	}, nil
+}
+
+func (n *Node) getNetworkInfo(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"version":     1,
+		"subversion":  "/fakenode:" + n.Coin.String() + "/",
+		"connections": 0,
+	}, nil
+}
+
+func (n *Node) getChainTips(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	tip := n.tipLocked()
+	return []map[string]interface{}{
+		{"height": tip.Height, "hash": tip.Hash, "branchlen": 0, "status": "active"},
+	}, nil
+}
+
+func (n *Node) getNewAddress(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var label string
+	if n.Coin == Dogecoin {
+		if err := maxParams(params, 1, "getnewaddress ( \"account\" )"); err != nil {
+			return nil, err
+		}
+		if _, err := param(params, 0, &label); err != nil {
+			return nil, err
+		}
+		return w.newAddressLocked(label), nil
+	}
+
+	if err := maxParams(params, 2, "getnewaddress ( \"label\" \"address_type\" )"); err != nil {
+		return nil, err
+	}
+	if _, err := param(params, 0, &label); err != nil {
+		return nil, err
+	}
+	if label == "*" {
+		return nil, &RPCError{Code: ErrWalletInvalidLabel, Message: "Invalid label name"}
+	}
+	var addressType string
+	if _, err := param(params, 1, &addressType); err != nil {
+		return nil, err
+	}
+	switch addressType {
+	case "", "legacy", "p2sh-segwit", "bech32", "bech32m":
+	default:
+		return nil, &RPCError{Code: ErrInvalidAddress, Message: fmt.Sprintf("Unknown address type '%s'", addressType)}
+	}
+	if w.WatchOnly && len(w.descriptors) == 0 {
+		return nil, &RPCError{Code: ErrWallet, Message: "Error: This wallet has no available keys"}
+	}
+	return w.newAddressLocked(label), nil
+}
+
+func (n *Node) getBalance(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var account string
+	hasAccount, err := param(params, 0, &account)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Coin == Dogecoin {
+		minConf := 1
+		if _, err := param(params, 1, &minConf); err != nil {
+			return nil, err
+		}
+		if !hasAccount || account == "*" {
+			return w.balanceLocked(minConf, nil), nil
+		}
+		return w.balanceLocked(minConf, &account), nil
+	}
+
+	if hasAccount && account != "*" {
+		return nil, &RPCError{Code: ErrMethodDeprecated, Message: "dummy first argument must be excluded or set to \"*\"."}
+	}
+	minConf := 0
+	if _, err := param(params, 1, &minConf); err != nil {
+		return nil, err
+	}
+	return w.balanceLocked(minConf, nil), nil
+}
+
+func (n *Node) listUnspent(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	minConf, maxConf := 1, 9999999
+	var addresses []string
+	if _, err := param(params, 0, &minConf); err != nil {
+		return nil, err
+	}
+	if _, err := param(params, 1, &maxConf); err != nil {
+		return nil, err
+	}
+	if _, err := param(params, 2, &addresses); err != nil {
+		return nil, err
+	}
+	for _, a := range addresses {
+		if a == "" {
+			return nil, invalidAddress(a)
+		}
+	}
+
+	result := []UTXO{}
+	for _, u := range w.utxos {
+		if u.Confirmations < minConf || u.Confirmations > maxConf {
+			continue
+		}
+		if len(addresses) > 0 && !containsString(addresses, u.Address) {
+			continue
+		}
+		result = append(result, u)
+	}
+	return result, nil
+}
+
+func (n *Node) getTransaction(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var txid string
+	if ok, err := param(params, 0, &txid); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "gettransaction \"txid\" ( include_watchonly )"}
+	}
+	var details []Transaction
+	for _, tx := range w.transactions {
+		if tx.TxID == txid {
+			details = append(details, tx)
+		}
+	}
+	if len(details) == 0 {
+		return nil, &RPCError{Code: ErrInvalidAddress, Message: "Invalid or non-wallet transaction id"}
+	}
+
+	var amount float64
+	for _, d := range details {
+		amount += d.Amount
+	}
+	return map[string]interface{}{
+		"txid":          txid,
+		"amount":        amount,
+		"confirmations": details[0].Confirmations,
+		"time":          details[0].Time,
+		"details":       details,
+	}, nil
+}
+
+func (n *Node) getReceivedByAddress(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var address string
+	if ok, err := param(params, 0, &address); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "getreceivedbyaddress \"address\" ( minconf )"}
+	}
+	if address == "" {
+		return nil, invalidAddress(address)
+	}
+	minConf := 1
+	if _, err := param(params, 1, &minConf); err != nil {
+		return nil, err
+	}
+	if _, ok := w.labels[address]; !ok {
+		return nil, &RPCError{Code: ErrWallet, Message: "Address not found in wallet"}
+	}
+
+	var received float64
+	for _, tx := range w.transactions {
+		if tx.Category == "receive" && tx.Address == address && tx.Confirmations >= minConf {
+			received += tx.Amount
+		}
+	}
+	return received, nil
+}
+
+func (n *Node) listTransactions(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	label := "*"
+	count, skip := 10, 0
+	if _, err := param(params, 0, &label); err != nil {
+		return nil, err
+	}
+	if _, err := param(params, 1, &count); err != nil {
+		return nil, err
+	}
+	if _, err := param(params, 2, &skip); err != nil {
+		return nil, err
+	}
+	if count < 0 || skip < 0 {
+		return nil, &RPCError{Code: ErrInvalidParameter, Message: "Negative count or from"}
+	}
+
+	type labeledTransaction struct {
+		Transaction
+		Label string `json:"label"`
+	}
+	filtered := []labeledTransaction{}
+	for _, tx := range w.transactions {
+		txLabel := w.labels[tx.Address]
+		if label != "*" && txLabel != label {
+			continue
+		}
+		filtered = append(filtered, labeledTransaction{tx, txLabel})
+	}
+
+	// The most recent transactions come last; skip counts from the end.
+	end := len(filtered) - skip
+	if end < 0 {
+		end = 0
+	}
+	start := end - count
+	if start < 0 {
+		start = 0
+	}
+	return filtered[start:end], nil
+}
+
+func (n *Node) sendToAddress(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	if n.Coin == Dogecoin {
+		if err := maxParams(params, 5, "sendtoaddress \"address\" amount ( \"comment\" \"comment_to\" subtractfeefromamount )"); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := maxParams(params, 10, "sendtoaddress \"address\" amount ( \"comment\" \"comment_to\" subtractfeefromamount replaceable conf_target \"estimate_mode\" avoid_reuse fee_rate )"); err != nil {
+			return nil, err
+		}
+	}
+
+	var address, comment, commentTo string
+	var amount float64
+	if ok, err := param(params, 0, &address); err != nil || !ok || address == "" {
+		return nil, invalidAddress(address)
+	}
+	if ok, err := param(params, 1, &amount); err != nil {
+		return nil, err
+	} else if !ok || amount <= 0 {
+		return nil, &RPCError{Code: ErrTypeError, Message: "Invalid amount for send"}
+	}
+	if _, err := param(params, 2, &comment); err != nil {
+		return nil, err
+	}
+	if _, err := param(params, 3, &commentTo); err != nil {
+		return nil, err
+	}
+	var subtractFee bool
+	if _, err := param(params, 4, &subtractFee); err != nil {
+		return nil, err
+	}
+
+	txid, err := w.spendLocked(amount)
+	if err != nil {
+		return nil, err
+	}
+	w.transactions = append(w.transactions, Transaction{
+		TxID:     txid,
+		Address:  address,
+		Category: "send",
+		Amount:   -amount,
+		Time:     time.Now().Unix(),
+		Comment:  comment,
+		To:       commentTo,
+	})
+	return txid, nil
+}
+
+func (n *Node) listLabels(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var purpose string
+	if _, err := param(params, 0, &purpose); err != nil {
+		return nil, err
+	}
+	switch purpose {
+	case "", "receive":
+		return w.sortedLabelsLocked(), nil
+	case "send":
+		return []string{}, nil
+	}
+	return nil, &RPCError{Code: ErrInvalidParameter, Message: "Invalid 'purpose' argument, must be a known purpose string, typically 'send', or 'receive'."}
+}
+
+func (n *Node) getAddressesByLabel(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var label string
+	if ok, err := param(params, 0, &label); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "getaddressesbylabel \"label\""}
+	}
+	result := map[string]map[string]string{}
+	for address, l := range w.labels {
+		if l == label {
+			result[address] = map[string]string{"purpose": "receive"}
+		}
+	}
+	if len(result) == 0 {
+		return nil, &RPCError{Code: ErrWalletInvalidLabel, Message: "No addresses with label " + label}
+	}
+	return result, nil
+}
+
+func (n *Node) setLabel(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var address, label string
+	if ok, err := param(params, 0, &address); err != nil || !ok || address == "" {
+		return nil, invalidAddress(address)
+	}
+	if ok, err := param(params, 1, &label); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "setlabel \"address\" \"label\""}
+	}
+	if label == "*" {
+		return nil, &RPCError{Code: ErrWalletInvalidLabel, Message: "Invalid label name"}
+	}
+	w.labels[address] = label
+	return nil, nil
+}
+
+func (n *Node) getAddressInfo(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var address string
+	if ok, err := param(params, 0, &address); err != nil || !ok || address == "" {
+		return nil, invalidAddress(address)
+	}
+	label, mine := w.labels[address]
+	labels := []string{}
+	if mine {
+		labels = append(labels, label)
+	}
+	return map[string]interface{}{
+		"address":     address,
+		"ismine":      mine && !w.WatchOnly,
+		"iswatchonly": mine && w.WatchOnly,
+		"solvable":    mine,
+		"labels":      labels,
+	}, nil
+}
+
+func descriptorChecksum(descriptor string) string {
+	sum := sha256.Sum256([]byte(descriptor))
+	return hex.EncodeToString(sum[:4])
+}
+
+// splitDescriptor separates a descriptor from its checksum. The fake checksum
+// is not the real descriptor checksum, only a stable function of the
+// descriptor, so it round-trips through getdescriptorinfo.
+func splitDescriptor(descriptor string) (string, string) {
+	if i := strings.LastIndex(descriptor, "#"); i >= 0 {
+		return descriptor[:i], descriptor[i+1:]
+	}
+	return descriptor, ""
+}
+
+func (n *Node) getDescriptorInfo(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var descriptor string
+	if ok, err := param(params, 0, &descriptor); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "getdescriptorinfo \"descriptor\""}
+	}
+	desc, checksum := splitDescriptor(descriptor)
+	if !strings.HasSuffix(desc, ")") || !strings.Contains(desc, "(") {
+		return nil, &RPCError{Code: ErrInvalidAddress, Message: "'" + desc + "' is not a valid descriptor function"}
+	}
+	expected := descriptorChecksum(desc)
+	if checksum != "" && checksum != expected {
+		return nil, &RPCError{Code: ErrInvalidAddress, Message: fmt.Sprintf("Provided checksum '%s' does not match computed checksum '%s'", checksum, expected)}
+	}
+	return map[string]interface{}{
+		"descriptor":     desc + "#" + expected,
+		"checksum":       expected,
+		"isrange":        strings.Contains(desc, "*"),
+		"issolvable":     true,
+		"hasprivatekeys": strings.Contains(desc, "prv"),
+	}, nil
+}
+
+func (n *Node) createWallet(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var name string
+	if ok, err := param(params, 0, &name); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "createwallet \"wallet_name\" ( disable_private_keys blank \"passphrase\" avoid_reuse descriptors load_on_startup )"}
+	}
+	var disablePrivateKeys bool
+	if _, err := param(params, 1, &disablePrivateKeys); err != nil {
+		return nil, err
+	}
+	if _, ok := n.wallets[name]; ok {
+		return nil, &RPCError{Code: ErrWallet, Message: "Wallet file verification failed. Failed to create database path '" + name + "'. Database already exists."}
+	}
+	n.wallets[name] = newWallet(n, name, disablePrivateKeys)
+	return map[string]string{"name": name, "warning": ""}, nil
+}
+
+func (n *Node) importDescriptors(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var requests []struct {
+		Desc      string          `json:"desc"`
+		Active    bool            `json:"active"`
+		Range     json.RawMessage `json:"range"`
+		Timestamp json.RawMessage `json:"timestamp"`
+		Internal  bool            `json:"internal"`
+	}
+	if ok, err := param(params, 0, &requests); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "importdescriptors \"requests\""}
+	}
+
+	type importResult struct {
+		Success bool      `json:"success"`
+		Error   *RPCError `json:"error,omitempty"`
+	}
+	results := make([]importResult, 0, len(requests))
+	for _, req := range requests {
+		desc, checksum := splitDescriptor(req.Desc)
+		switch {
+		case checksum == "":
+			results = append(results, importResult{Error: &RPCError{Code: ErrInvalidAddress, Message: "Missing checksum"}})
+		case checksum != descriptorChecksum(desc):
+			results = append(results, importResult{Error: &RPCError{Code: ErrInvalidAddress, Message: "Provided checksum '" + checksum + "' does not match computed checksum"}})
+		case len(req.Timestamp) == 0:
+			results = append(results, importResult{Error: &RPCError{Code: ErrTypeError, Message: "Missing required timestamp field for key"}})
+		case !w.WatchOnly && !strings.Contains(desc, "prv"):
+			results = append(results, importResult{Error: &RPCError{Code: ErrWallet, Message: "Cannot import descriptor without private keys to a wallet with private keys enabled"}})
+		default:
+			w.descriptors = append(w.descriptors, req.Desc)
+			results = append(results, importResult{Success: true})
+		}
+	}
+	return results, nil
+}
+
+func (n *Node) listAccounts(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	minConf := 1
+	if _, err := param(params, 0, &minConf); err != nil {
+		return nil, err
+	}
+	accounts := map[string]float64{}
+	for _, account := range w.sortedLabelsLocked() {
+		account := account
+		accounts[account] = w.balanceLocked(minConf, &account)
+	}
+	if _, ok := accounts[""]; !ok {
+		accounts[""] = 0
+	}
+	return accounts, nil
+}
+
+func (n *Node) getAddressesByAccount(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var account string
+	if ok, err := param(params, 0, &account); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "getaddressesbyaccount \"account\""}
+	}
+	addresses := []string{}
+	for address, a := range w.labels {
+		if a == account {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+func (n *Node) setAccount(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var address, account string
+	if ok, err := param(params, 0, &address); err != nil || !ok || address == "" {
+		return nil, invalidAddress(address)
+	}
+	if _, err := param(params, 1, &account); err != nil {
+		return nil, err
+	}
+	if _, ok := w.labels[address]; !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "setaccount can only be used with own address"}
+	}
+	w.labels[address] = account
+	return nil, nil
+}
+
+func (n *Node) getAccount(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var address string
+	if ok, err := param(params, 0, &address); err != nil || !ok || address == "" {
+		return nil, invalidAddress(address)
+	}
+	return w.labels[address], nil
+}
+
+func (n *Node) validateAddress(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var address string
+	if ok, err := param(params, 0, &address); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "validateaddress \"address\""}
+	}
+	if address == "" {
+		return map[string]interface{}{"isvalid": false}, nil
+	}
+	account, mine := w.labels[address]
+	result := map[string]interface{}{
+		"isvalid":     true,
+		"address":     address,
+		"ismine":      mine && !w.watched[address],
+		"iswatchonly": w.watched[address],
+	}
+	if mine {
+		result["account"] = account
+	}
+	return result, nil
+}
+
+func (n *Node) importMulti(w *Wallet, params []json.RawMessage) (interface{}, error) {
+	var requests []struct {
+		ScriptPubKey json.RawMessage `json:"scriptPubKey"`
+		Timestamp    json.RawMessage `json:"timestamp"`
+		WatchOnly    bool            `json:"watchonly"`
+	}
+	if ok, err := param(params, 0, &requests); err != nil || !ok {
+		return nil, &RPCError{Code: ErrMisc, Message: "importmulti \"requests\" ( \"options\" )"}
+	}
+
+	type importResult struct {
+		Success bool      `json:"success"`
+		Error   *RPCError `json:"error,omitempty"`
+	}
+	results := make([]importResult, 0, len(requests))
+	for _, req := range requests {
+		// scriptPubKey is either a hex script or {"address": "..."}.
+		var script string
+		if err := json.Unmarshal(req.ScriptPubKey, &script); err != nil {
+			var byAddress struct {
+				Address string `json:"address"`
+			}
+			if err := json.Unmarshal(req.ScriptPubKey, &byAddress); err == nil {
+				script = byAddress.Address
+			}
+		}
+		switch {
+		case script == "":
+			results = append(results, importResult{Error: &RPCError{Code: ErrInvalidAddress, Message: "Invalid scriptPubKey"}})
+		case len(req.Timestamp) == 0:
+			results = append(results, importResult{Error: &RPCError{Code: ErrTypeError, Message: "Missing required timestamp field for key"}})
+		default:
+			if _, ok := w.labels[script]; !ok {
+				w.labels[script] = ""
+			}
+			w.watched[script] = req.WatchOnly
+			results = append(results, importResult{Success: true})
+		}
+	}
+	return results, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,266 @@
+// Package fakenode runs an in-process bitcoind, litecoind or dogecoind that
+// speaks the subset of JSON-RPC used by the UTXO coin packages. Wallets, UTXOs,
+// blocks and errors are scripted from Go, so the HTTP API can be exercised with
+// httptest and no daemons:
+//
+//	node := fakenode.New(fakenode.Dogecoin)
+//	defer node.Close()
+//	router := mux.NewRouter()
+//	dogecoin.RegisterHandlers(router, dogecoin.CoinConfig{
+//		RPCIP: node.Host(), RPCPort: node.Port(), RPCUser: node.User, RPCPassword: node.Password,
+//	})
+package fakenode
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+type Coin int
+
+const (
+	Bitcoin Coin = iota
+	Litecoin
+	Dogecoin
+)
+
+func (c Coin) String() string {
+	switch c {
+	case Bitcoin:
+		return "bitcoin"
+	case Litecoin:
+		return "litecoin"
+	case Dogecoin:
+		return "dogecoin"
+	}
+	return fmt.Sprintf("Coin(%d)", int(c))
+}
+
+// Error codes returned by the Bitcoin Core family of daemons.
+const (
+	ErrMisc               = -1
+	ErrTypeError          = -3
+	ErrWallet             = -4
+	ErrInvalidAddress     = -5
+	ErrInsufficientFunds  = -6
+	ErrInvalidParameter   = -8
+	ErrWalletInvalidLabel = -11
+	ErrWalletNotFound     = -18
+	ErrMethodNotFound     = -32601
+)
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// HandlerFunc answers a JSON-RPC method. Returning an *RPCError reports it to
+// the client as a JSON-RPC error; any other error is reported as ErrMisc.
+type HandlerFunc func(wallet *Wallet, params []json.RawMessage) (interface{}, error)
+
+// Call records a request received by the node.
+type Call struct {
+	Wallet string
+	Method string
+	Params []json.RawMessage
+}
+
+type Node struct {
+	Coin     Coin
+	User     string
+	Password string
+
+	mu       sync.Mutex
+	server   *httptest.Server
+	wallets  map[string]*Wallet
+	blocks   []*Block
+	failures map[string][]*RPCError
+	handlers map[string]HandlerFunc
+	calls    []Call
+	nextTx   int
+}
+
+// New starts a fake node for the coin with a loaded default wallet and a
+// genesis block.
+func New(coin Coin) *Node {
+	n := &Node{
+		Coin:     coin,
+		User:     "user",
+		Password: "password",
+		wallets:  map[string]*Wallet{},
+		failures: map[string][]*RPCError{},
+		handlers: map[string]HandlerFunc{},
+	}
+	n.wallets[""] = newWallet(n, "", false)
+	n.mineLocked(1)
+	n.server = httptest.NewServer(http.HandlerFunc(n.serveHTTP))
+	return n
+}
+
+func (n *Node) URL() string {
+	return n.server.URL
+}
+
+func (n *Node) Host() string {
+	host, _, _ := net.SplitHostPort(n.server.Listener.Addr().String())
+	return host
+}
+
+func (n *Node) Port() string {
+	_, port, _ := net.SplitHostPort(n.server.Listener.Addr().String())
+	return port
+}
+
+func (n *Node) Close() {
+	n.server.Close()
+}
+
+// Wallet returns the loaded wallet with the given name, the default wallet
+// being "". It returns nil when no such wallet is loaded.
+func (n *Node) Wallet(name string) *Wallet {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.wallets[name]
+}
+
+// AddWallet loads a new empty wallet, replacing any wallet of the same name.
+func (n *Node) AddWallet(name string, watchOnly bool) *Wallet {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	w := newWallet(n, name, watchOnly)
+	n.wallets[name] = w
+	return w
+}
+
+// FailNext makes the next call of method fail with err. Several failures for
+// the same method are returned in order.
+func (n *Node) FailNext(method string, err *RPCError) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.failures[method] = append(n.failures[method], err)
+}
+
+// Handle overrides or adds the implementation of a method. The handler runs
+// without the node lock held.
+func (n *Node) Handle(method string, handler HandlerFunc) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handlers[method] = handler
+}
+
+// Calls returns the requests received so far, oldest first.
+func (n *Node) Calls() []Call {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Call(nil), n.calls...)
+}
+
+// Called reports whether the node received a request for method.
+func (n *Node) Called(method string) bool {
+	for _, call := range n.Calls() {
+		if call.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != n.User || password != n.Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	walletName := ""
+	if r.URL.Path != "/" && r.URL.Path != "" {
+		// Dogecoin Core predates multiwallet and has no /wallet/ endpoint.
+		if n.Coin == Dogecoin || !strings.HasPrefix(r.URL.Path, "/wallet/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		walletName = strings.TrimPrefix(r.URL.Path, "/wallet/")
+	}
+
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeResponse(w, nil, nil, &RPCError{Code: -32700, Message: "Parse error"})
+		return
+	}
+
+	result, rpcErr := n.call(walletName, req.Method, req.Params)
+	writeResponse(w, req.ID, result, rpcErr)
+}
+
+func writeResponse(w http.ResponseWriter, id json.RawMessage, result interface{}, rpcErr *RPCError) {
+	w.Header().Set("Content-Type", "application/json")
+	if rpcErr != nil {
+		// Like the real daemons, errors come with a non-200 status.
+		if rpcErr.Code == ErrMethodNotFound {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	json.NewEncoder(w).Encode(struct {
+		Result interface{}     `json:"result"`
+		Error  *RPCError       `json:"error"`
+		ID     json.RawMessage `json:"id"`
+	}{result, rpcErr, id})
+}
+
+func (n *Node) call(walletName, method string, params []json.RawMessage) (interface{}, *RPCError) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.calls = append(n.calls, Call{Wallet: walletName, Method: method, Params: params})
+
+	if failures := n.failures[method]; len(failures) > 0 {
+		n.failures[method] = failures[1:]
+		return nil, failures[0]
+	}
+
+	wallet := n.wallets[walletName]
+	if wallet == nil {
+		return nil, &RPCError{Code: ErrWalletNotFound, Message: "Requested wallet does not exist or is not loaded"}
+	}
+
+	var result interface{}
+	var err error
+	if handler, ok := n.handlers[method]; ok {
+		// Scripted handlers may use the public Node and Wallet methods,
+		// which take the lock themselves.
+		n.mu.Unlock()
+		result, err = handler(wallet, params)
+		n.mu.Lock()
+	} else if handler, ok := n.builtin(method); ok {
+		result, err = handler(wallet, params)
+	} else {
+		return nil, &RPCError{Code: ErrMethodNotFound, Message: "Method not found"}
+	}
+	if err != nil {
+		if rpcErr, ok := err.(*RPCError); ok {
+			return nil, rpcErr
+		}
+		return nil, &RPCError{Code: ErrMisc, Message: err.Error()}
+	}
+	return result, nil
+}
+
+func (n *Node) newTxID() string {
+	n.nextTx++
+	return fmt.Sprintf("%064x", n.nextTx)
+}
@@ -0,0 +1,191 @@
+package fakenode
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type UTXO struct {
+	TxID          string  `json:"txid"`
+	Vout          int     `json:"vout"`
+	Address       string  `json:"address"`
+	Amount        float64 `json:"amount"`
+	Confirmations int     `json:"confirmations"`
+	Spendable     bool    `json:"spendable"`
+}
+
+type Transaction struct {
+	TxID          string  `json:"txid"`
+	Address       string  `json:"address"`
+	Category      string  `json:"category"`
+	Amount        float64 `json:"amount"`
+	Confirmations int     `json:"confirmations"`
+	Time          int64   `json:"time"`
+	Comment       string  `json:"comment,omitempty"`
+	To            string  `json:"to,omitempty"`
+}
+
+// Wallet is a loaded wallet of the fake node. Its methods are safe to call
+// while the node is serving requests.
+type Wallet struct {
+	Name      string
+	WatchOnly bool
+
+	node         *Node
+	labels       map[string]string
+	watched      map[string]bool
+	utxos        []UTXO
+	transactions []Transaction
+	descriptors  []string
+	nextAddress  int
+}
+
+func newWallet(n *Node, name string, watchOnly bool) *Wallet {
+	return &Wallet{
+		Name:      name,
+		WatchOnly: watchOnly,
+		node:      n,
+		labels:    map[string]string{},
+		watched:   map[string]bool{},
+	}
+}
+
+// NewAddress returns a fresh address of the wallet with the given label.
+func (w *Wallet) NewAddress(label string) string {
+	w.node.mu.Lock()
+	defer w.node.mu.Unlock()
+	return w.newAddressLocked(label)
+}
+
+// Receive credits the wallet with a new confirmed UTXO paying to address and
+// returns its transaction id. Unknown addresses are added to the wallet.
+func (w *Wallet) Receive(address string, amount float64, confirmations int) string {
+	w.node.mu.Lock()
+	defer w.node.mu.Unlock()
+
+	if _, ok := w.labels[address]; !ok {
+		w.labels[address] = ""
+	}
+	txid := w.node.newTxID()
+	w.utxos = append(w.utxos, UTXO{
+		TxID:          txid,
+		Address:       address,
+		Amount:        amount,
+		Confirmations: confirmations,
+		Spendable:     !w.WatchOnly,
+	})
+	w.transactions = append(w.transactions, Transaction{
+		TxID:          txid,
+		Address:       address,
+		Category:      "receive",
+		Amount:        amount,
+		Confirmations: confirmations,
+		Time:          time.Now().Unix(),
+	})
+	return txid
+}
+
+func (w *Wallet) UTXOs() []UTXO {
+	w.node.mu.Lock()
+	defer w.node.mu.Unlock()
+	return append([]UTXO(nil), w.utxos...)
+}
+
+func (w *Wallet) Transactions() []Transaction {
+	w.node.mu.Lock()
+	defer w.node.mu.Unlock()
+	return append([]Transaction(nil), w.transactions...)
+}
+
+// Descriptors returns the descriptors imported with importdescriptors.
+func (w *Wallet) Descriptors() []string {
+	w.node.mu.Lock()
+	defer w.node.mu.Unlock()
+	return append([]string(nil), w.descriptors...)
+}
+
+// Label returns the label, or account on dogecoin, of an address and whether
+// the address belongs to the wallet.
+func (w *Wallet) Label(address string) (string, bool) {
+	w.node.mu.Lock()
+	defer w.node.mu.Unlock()
+	label, ok := w.labels[address]
+	return label, ok
+}
+
+func (w *Wallet) newAddressLocked(label string) string {
+	w.nextAddress++
+	var address string
+	switch w.node.Coin {
+	case Bitcoin:
+		address = fmt.Sprintf("bcrt1qfake%s%06d", w.Name, w.nextAddress)
+	case Litecoin:
+		address = fmt.Sprintf("rltc1qfake%s%06d", w.Name, w.nextAddress)
+	default:
+		address = fmt.Sprintf("nfake%s%06d", w.Name, w.nextAddress)
+	}
+	w.labels[address] = label
+	return address
+}
+
+func (w *Wallet) balanceLocked(minConf int, label *string) float64 {
+	var balance float64
+	for _, u := range w.utxos {
+		if u.Confirmations < minConf {
+			continue
+		}
+		if label != nil && w.labels[u.Address] != *label {
+			continue
+		}
+		balance += u.Amount
+	}
+	return balance
+}
+
+func (w *Wallet) sortedLabelsLocked() []string {
+	seen := map[string]bool{}
+	labels := []string{}
+	for _, label := range w.labels {
+		if !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// spendLocked removes UTXOs worth at least amount and returns the change,
+// which is credited back to the wallet as an unconfirmed UTXO.
+func (w *Wallet) spendLocked(amount float64) (string, error) {
+	if w.WatchOnly {
+		return "", &RPCError{Code: ErrWallet, Message: "Error: Private keys are disabled for this wallet"}
+	}
+	if w.balanceLocked(0, nil) < amount {
+		return "", &RPCError{Code: ErrInsufficientFunds, Message: "Insufficient funds"}
+	}
+
+	var selected float64
+	remaining := w.utxos[:0]
+	for _, u := range w.utxos {
+		if selected < amount {
+			selected += u.Amount
+			continue
+		}
+		remaining = append(remaining, u)
+	}
+	w.utxos = remaining
+
+	txid := w.node.newTxID()
+	if change := selected - amount; change > 0 {
+		w.utxos = append(w.utxos, UTXO{
+			TxID:      txid,
+			Vout:      1,
+			Address:   w.newAddressLocked(""),
+			Amount:    change,
+			Spendable: true,
+		})
+	}
+	return txid, nil
+}
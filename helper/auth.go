@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type apiClientKey struct{}
+
+var (
+	apiKeysMu sync.RWMutex
+	apiKeys   = map[string]string{}
+)
+
+// SetAPIKeys replaces the accepted API keys, mapping each key to the name of
+// the client it was issued to.
+func SetAPIKeys(keys map[string]string) {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	apiKeys = make(map[string]string, len(keys))
+	for key, client := range keys {
+		apiKeys[key] = client
+	}
+}
+
+// RequireAPIKey rejects requests without a known key in the X-API-Key header
+// or an "Authorization: Bearer" header. With no keys configured every request
+// is rejected.
+func RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+
+		apiKeysMu.RLock()
+		client, ok := apiKeys[key]
+		apiKeysMu.RUnlock()
+		if key == "" || !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), apiClientKey{}, client)))
+	}
+}
+
+// APIClient returns the name of the client authenticated by RequireAPIKey, or
+// "" for unauthenticated requests.
+func APIClient(r *http.Request) string {
+	client, _ := r.Context().Value(apiClientKey{}).(string)
+	return client
+}
@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/ethereum/go-ethereum/common"
@@ -70,10 +71,17 @@ func DerivePath(extKey *hdkeychain.ExtendedKey, path string) (*hdkeychain.Extend
 	childKey := extKey
 
 	for _, segment := range segments {
-		index, err := strconv.ParseUint(segment, 10, 32)
+		hardened := strings.HasSuffix(segment, "'")
+		index, err := strconv.ParseUint(strings.TrimSuffix(segment, "'"), 10, 32)
 		if err != nil {
 			return nil, err
 		}
+		if hardened {
+			if index >= hdkeychain.HardenedKeyStart {
+				return nil, fmt.Errorf("hardened index %d out of range", index)
+			}
+			index += hdkeychain.HardenedKeyStart
+		}
 
 		childKey, err = childKey.Child(uint32(index))
 		if err != nil {
@@ -113,6 +121,27 @@ func GeneratePriv(extendedKey string, derivePath string) string {
 	return address.Hex()
 }
 
+// DerivePrivateKey returns the signing key at path, e.g. "0'/0/5", below an
+// extended private key.
+func DerivePrivateKey(extendedKey string, derivePath string) (*ecdsa.PrivateKey, error) {
+	extKey, err := hdkeychain.NewKeyFromString(extendedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	childKey, err := DerivePath(extKey, derivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := childKey.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return privateKey.ToECDSA(), nil
+}
+
 func GeneratePub(publicKey string, index int32) common.Address {
 
 	extPubKeyStr := publicKey
@@ -139,3 +168,54 @@ func GeneratePub(publicKey string, index int32) common.Address {
 	return ethAddress
 
 }
+
+// DerivePubKeys returns the compressed public keys of the children start to
+// end (inclusive) of a branch, 0 for receiving and 1 for change addresses.
+func DerivePubKeys(extendedKey string, branch, start, end uint32) ([][]byte, error) {
+	extKey, err := hdkeychain.NewKeyFromString(extendedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	branchKey, err := extKey.Child(branch)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeys := make([][]byte, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		childKey, err := branchKey.Child(i)
+		if err != nil {
+			return nil, err
+		}
+		pubKey, err := childKey.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		pubKeys = append(pubKeys, pubKey.SerializeCompressed())
+	}
+
+	return pubKeys, nil
+}
+
+// DeriveExtendedPubKey returns the extended public key at path, e.g. "0'",
+// below an extended private key, so that its children can be derived
+// without the private key.
+func DeriveExtendedPubKey(extendedKey string, derivePath string) (string, error) {
+	extKey, err := hdkeychain.NewKeyFromString(extendedKey)
+	if err != nil {
+		return "", err
+	}
+
+	childKey, err := DerivePath(extKey, derivePath)
+	if err != nil {
+		return "", err
+	}
+
+	pubKey, err := childKey.Neuter()
+	if err != nil {
+		return "", err
+	}
+
+	return pubKey.String(), nil
+}
@@ -3,20 +3,27 @@ package litecoin
 import (
 	"bytes"
 	"context"
+	"crypto-api/database"
+	"crypto-api/helper"
 	"encoding/json"
+	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"io/ioutil"
 	"net/http"
+	neturl "net/url"
 	"path/filepath"
 	"runtime"
 )
 
 type CoinConfig struct {
-	RPCIP       string `json:"rpcip"`
-	RPCPort     string `json:"rpcport"`
-	RPCUser     string `json:"rpcuser"`
-	RPCPassword string `json:"rpcpassword"`
+	RPCIP        string         `json:"rpcip"`
+	RPCPort      string         `json:"rpcport"`
+	RPCUser      string         `json:"rpcuser"`
+	RPCPassword  string         `json:"rpcpassword"`
+	Wallet       string         `json:"wallet,omitempty"`
+	RPCAllowlist []string       `json:"rpcallowlist,omitempty"`
+	Policy       SpendingPolicy `json:"policy"`
 }
 
 type JSONRPCRequest struct {
@@ -28,6 +35,7 @@ type JSONRPCRequest struct {
 
 var (
 	redisClient *redis.Client
+	db          *database.DB
 )
 
 func InitRedis() {
@@ -38,15 +46,22 @@ func InitRedis() {
 	})
 }
 
+func InitDatabase(d *database.DB) {
+	db = d
+}
+
 func RegisterHandlers(router *mux.Router, config CoinConfig) {
 	router.HandleFunc("/tx/{txid}", transactionHandler(config)).Methods("GET")
 	router.HandleFunc("/getnewaddress", getGetNewAddressHandler(config)).Methods("POST")
 	router.HandleFunc("/getbalance", getBalanceHandler(config)).Methods("POST")
 	router.HandleFunc("/getaddressbalance", getAddressBalanceHandler(config)).Methods("POST")
-	router.HandleFunc("/sendtransactions", sendTransactionsHandler(config)).Methods("POST")
+	router.HandleFunc("/sendtransactions", helper.RequireAPIKey(sendTransactionsHandler(config))).Methods("POST")
 	router.HandleFunc("/getreceivedbyaddress", getAddressReceivedHandler(config)).Methods("POST")
 	router.HandleFunc("/listunspent", listUnspentHandler(config)).Methods("POST")
 	router.HandleFunc("/listtransactionsbyaddress", listTransactionsByAddressHandler(config)).Methods("POST")
+	router.HandleFunc("/importwatchonly", helper.RequireAPIKey(importWatchOnlyHandler(config))).Methods("POST")
+	router.HandleFunc("/rpc", helper.RequireAPIKey(rawRPCHandler(config))).Methods("POST")
+	registerLabelHandlers(router, config)
 
 }
 
@@ -339,38 +354,67 @@ func sendTransactionsHandler(config CoinConfig) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		params := []interface{}{
-			sendReq.Address,
-			sendReq.Amount,
-		}
-		if sendReq.Comment != "" {
-			params = append(params, sendReq.Comment)
+
+		if violations := checkSpendingPolicy(config.Policy, sendReq.Address, sendReq.Amount); len(violations) > 0 {
+			writePolicyViolations(w, violations)
+			return
 		}
-		if sendReq.CommentTo != "" {
-			params = append(params, sendReq.CommentTo)
+
+		// The daily caps are counted per client, so every spend must be
+		// attributed to one.
+		client := helper.APIClient(r)
+		if client == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
-		params = append(params, sendReq.SubtractFeeFromAmount)
-		if sendReq.Replaceable != nil {
-			params = append(params, *sendReq.Replaceable)
+		reservation, violations, err := reserveDailySpend(config.Policy, client, sendReq.Amount)
+		if err != nil {
+			http.Error(w, "Error checking spending limits", http.StatusInternalServerError)
+			return
 		}
-		if sendReq.ConfTarget != nil {
-			params = append(params, *sendReq.ConfTarget)
+		if len(violations) > 0 {
+			writePolicyViolations(w, violations)
+			return
 		}
-		if sendReq.EstimateMode != "" {
-			params = append(params, sendReq.EstimateMode)
+
+		// sendtoaddress takes positional parameters: an omitted one is sent
+		// as null so the ones after it keep their position.
+		params := []interface{}{
+			sendReq.Address,
+			sendReq.Amount,
+			sendReq.Comment,
+			sendReq.CommentTo,
+			sendReq.SubtractFeeFromAmount,
+		}
+		if sendReq.Replaceable != nil || sendReq.ConfTarget != nil || sendReq.EstimateMode != "" {
+			var estimateMode interface{}
+			if sendReq.EstimateMode != "" {
+				estimateMode = sendReq.EstimateMode
+			}
+			params = append(params, sendReq.Replaceable, sendReq.ConfTarget, estimateMode)
 		}
 
 		response, err := makeJSONRPCRequest(config, "sendtoaddress", params)
 		if err != nil {
+			releaseDailySpend(client, reservation)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if rpcError(response) != nil {
+			releaseDailySpend(client, reservation)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(response)
 	}
 }
 
 func makeJSONRPCRequest(config CoinConfig, method string, params interface{}) ([]byte, error) {
+	return makeWalletRPCRequest(config, config.Wallet, method, params)
+}
+
+// makeWalletRPCRequest sends the request to the /wallet/<name> endpoint when a
+// wallet is given, so wallet RPCs reach that wallet on a multiwallet node.
+func makeWalletRPCRequest(config CoinConfig, wallet string, method string, params interface{}) ([]byte, error) {
 	request := JSONRPCRequest{
 		ID:      1,
 		JsonRpc: "1.0", // JSON-RPC versiyonunu 1.0 olarak güncelledik
@@ -383,6 +427,9 @@ func makeJSONRPCRequest(config CoinConfig, method string, params interface{}) ([
 	}
 
 	url := "http://" + config.RPCUser + ":" + config.RPCPassword + "@" + config.RPCIP + ":" + config.RPCPort
+	if wallet != "" {
+		url += "/wallet/" + neturl.PathEscape(wallet)
+	}
 	resp, err := http.Post(url, "application/json", bytes.NewBuffer(requestBytes))
 	if err != nil {
 		return nil, err
@@ -397,6 +444,23 @@ func makeJSONRPCRequest(config CoinConfig, method string, params interface{}) ([
 	return responseBytes, nil
 }
 
+// rpcError returns the error reported by the node in a JSON-RPC response.
+func rpcError(response []byte) error {
+	var rpcResponse struct {
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(response, &rpcResponse); err != nil {
+		return err
+	}
+	if rpcResponse.Error != nil {
+		return fmt.Errorf("rpc error %d: %s", rpcResponse.Error.Code, rpcResponse.Error.Message)
+	}
+	return nil
+}
+
 // redis
 func isTransactionProcessed(txid string) (bool, error) {
 	paketadi := getPackageName()
@@ -0,0 +1,202 @@
+package litecoin
+
+import (
+	"crypto-api/database"
+	"database/sql"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func registerLabelHandlers(router *mux.Router, config CoinConfig) {
+	router.HandleFunc("/listlabels", listLabelsHandler(config)).Methods("POST")
+	router.HandleFunc("/getaddressesbylabel", getAddressesByLabelHandler(config)).Methods("POST")
+	router.HandleFunc("/setlabel", setLabelHandler(config)).Methods("POST")
+	router.HandleFunc("/getaddressinfo", getAddressInfoHandler(config)).Methods("POST")
+	router.HandleFunc("/addressbook/search", searchAddressBookHandler()).Methods("POST")
+	router.HandleFunc("/addressbook/{address}", getAddressBookHandler(config)).Methods("GET")
+}
+
+func listLabelsHandler(config CoinConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Purpose string `json:"purpose,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		params := []interface{}{}
+		if req.Purpose != "" {
+			params = append(params, req.Purpose)
+		}
+
+		response, err := makeJSONRPCRequest(config, "listlabels", params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+func getAddressesByLabelHandler(config CoinConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Label string `json:"label"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response, err := makeJSONRPCRequest(config, "getaddressesbylabel", []interface{}{req.Label})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+func setLabelHandler(config CoinConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Address     string          `json:"address"`
+			Label       string          `json:"label"`
+			CustomerRef string          `json:"customer_ref,omitempty"`
+			Metadata    json.RawMessage `json:"metadata,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Address == "" {
+			http.Error(w, "Address is required", http.StatusBadRequest)
+			return
+		}
+
+		response, err := makeJSONRPCRequest(config, "setlabel", []interface{}{req.Address, req.Label})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := rpcError(response); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		if db != nil {
+			entry := &database.Address{
+				Coin:        getPackageName(),
+				Address:     req.Address,
+				Label:       req.Label,
+				CustomerRef: req.CustomerRef,
+				Metadata:    req.Metadata,
+			}
+			if err := db.UpsertAddress(entry); err != nil {
+				http.Error(w, "Error saving address book entry", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+func getAddressInfoHandler(config CoinConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Address string `json:"address"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Address == "" {
+			http.Error(w, "Address is required", http.StatusBadRequest)
+			return
+		}
+
+		response, err := makeJSONRPCRequest(config, "getaddressinfo", []interface{}{req.Address})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+// getAddressBookHandler answers "whose address is this?" by combining the
+// address book entry with what the node knows about the address.
+func getAddressBookHandler(config CoinConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			http.Error(w, "Address book is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		address := mux.Vars(r)["address"]
+		entry, err := db.GetAddress(getPackageName(), address)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Address not found in address book", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error reading address book", http.StatusInternalServerError)
+			return
+		}
+
+		response, err := makeJSONRPCRequest(config, "getaddressinfo", []interface{}{address})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Entry       *database.Address `json:"entry"`
+			AddressInfo json.RawMessage   `json:"addressinfo"`
+		}{entry, response})
+	}
+}
+
+func searchAddressBookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			http.Error(w, "Address book is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		var req struct {
+			CustomerRef string `json:"customer_ref,omitempty"`
+			Label       string `json:"label,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.CustomerRef == "" && req.Label == "" {
+			http.Error(w, "customer_ref or label is required", http.StatusBadRequest)
+			return
+		}
+
+		entries, err := db.FindAddresses(getPackageName(), req.CustomerRef, req.Label)
+		if err != nil {
+			http.Error(w, "Error reading address book", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
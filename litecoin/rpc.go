@@ -0,0 +1,128 @@
+package litecoin
+
+import (
+	"crypto-api/database"
+	"crypto-api/helper"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// defaultRPCAllowlist is used when the coin config has no rpcallowlist. It only
+// holds read-only methods; anything that spends, exports keys or stops the
+// node, such as dumpprivkey or stop, has to be allowlisted explicitly.
+var defaultRPCAllowlist = []string{
+	"getbestblockhash",
+	"getblock",
+	"getblockchaininfo",
+	"getblockcount",
+	"getblockhash",
+	"getblockheader",
+	"getchaintips",
+	"getconnectioncount",
+	"getdifficulty",
+	"getmempoolentry",
+	"getmempoolinfo",
+	"getnettotals",
+	"getnetworkinfo",
+	"getpeerinfo",
+	"getrawmempool",
+	"getrawtransaction",
+	"gettxout",
+	"getwalletinfo",
+	"decoderawtransaction",
+	"decodescript",
+	"estimatesmartfee",
+	"uptime",
+	"validateaddress",
+}
+
+func rpcAllowlist(config CoinConfig) map[string]bool {
+	methods := config.RPCAllowlist
+	if len(methods) == 0 {
+		methods = defaultRPCAllowlist
+	}
+	allowed := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		allowed[method] = true
+	}
+	return allowed
+}
+
+// rawRPCHandler forwards an allowlisted JSON-RPC call to the node and records
+// every attempt, allowed or not, in the audit trail.
+func rawRPCHandler(config CoinConfig) http.HandlerFunc {
+	allowed := rpcAllowlist(config)
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Method == "" {
+			http.Error(w, "Method is required", http.StatusBadRequest)
+			return
+		}
+
+		params := []json.RawMessage{}
+		if len(req.Params) > 0 && string(req.Params) != "null" {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				http.Error(w, "Params must be an array", http.StatusBadRequest)
+				return
+			}
+		}
+
+		audit := &database.RPCAudit{
+			Coin:    getPackageName(),
+			Client:  helper.APIClient(r),
+			Method:  req.Method,
+			Params:  req.Params,
+			Allowed: allowed[req.Method],
+		}
+
+		if !audit.Allowed {
+			audit.Error = "method not allowed"
+			recordRPCAudit(audit)
+			http.Error(w, "Method not allowed", http.StatusForbidden)
+			return
+		}
+
+		if spendingMethods[req.Method] {
+			audit.Allowed = false
+			audit.Error = "spending method"
+			recordRPCAudit(audit)
+			http.Error(w, "Spending methods must go through /sendtransactions", http.StatusForbidden)
+			return
+		}
+
+		response, err := makeJSONRPCRequest(config, req.Method, params)
+		if err != nil {
+			audit.Error = err.Error()
+			recordRPCAudit(audit)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := rpcError(response); err != nil {
+			audit.Error = err.Error()
+		}
+		recordRPCAudit(audit)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}
+}
+
+func recordRPCAudit(audit *database.RPCAudit) {
+	log.Printf("rpc audit: coin=%s client=%s method=%s allowed=%t error=%q", audit.Coin, audit.Client, audit.Method, audit.Allowed, audit.Error)
+	if db == nil {
+		return
+	}
+	if err := db.CreateRPCAudit(audit); err != nil {
+		log.Printf("Error recording rpc audit: %v", err)
+	}
+}
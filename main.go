@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto-api/bitcoin"
+	"crypto-api/database"
 	"crypto-api/dogecoin"
 	"crypto-api/ethereum"
 	"crypto-api/helper"
 	"crypto-api/litecoin"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/common-nighthawk/go-figure"
 	"github.com/fatih/color"
@@ -17,16 +20,19 @@ import (
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"time"
 )
 
 type Config struct {
-	Bitcoin  bitcoin.CoinConfig      `json:"bitcoin"`
-	Litecoin litecoin.CoinConfig     `json:"litecoin"`
-	Dogecoin dogecoin.CoinConfig     `json:"dogecoin"`
-	Ethereum ethereum.EthereumConfig `json:"ethereum"`
+	Bitcoin  bitcoin.CoinConfig        `json:"bitcoin"`
+	Litecoin litecoin.CoinConfig       `json:"litecoin"`
+	Dogecoin dogecoin.CoinConfig       `json:"dogecoin"`
+	Ethereum ethereum.EthereumConfig   `json:"ethereum"`
+	EVM      []ethereum.EthereumConfig `json:"evm"` // further EVM chains, under /api/evm/{name}
+	APIKeys  map[string]string         `json:"apikeys"`
 }
 
 type responseWriter struct {
@@ -34,10 +40,35 @@ type responseWriter struct {
 	body *bytes.Buffer
 }
 
+// maxLoggedBody caps how much of a response is kept for the log, so that
+// streamed responses are not buffered whole.
+const maxLoggedBody = 4096
+
 func (rw *responseWriter) Write(b []byte) (int, error) {
-	rw.body.Write(b)
+	if room := maxLoggedBody - rw.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		rw.body.Write(b[:room])
+	}
 	return rw.ResponseWriter.Write(b)
 }
+
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets WebSocket handlers take over the connection.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	return hijacker.Hijack()
+}
+
 func createHD() {
 	mnemonic := helper.GenerateMnemonic()
 	//privateKey, publicKey, seed := helper.DeriveKeys(mnemonic, "m/44'/60'/0'/0") // bip32
@@ -62,6 +93,35 @@ func main() {
 	var config Config
 	json.NewDecoder(configFile).Decode(&config)
 
+	db, err := database.New()
+	if err != nil {
+		log.Fatalf("Error connecting to the database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.CreateAddressBookTable(); err != nil {
+		log.Printf("Error creating address book table: %v", err)
+	}
+	if err := db.CreateRPCAuditTable(); err != nil {
+		log.Printf("Error creating rpc audit table: %v", err)
+	}
+	bitcoin.InitDatabase(db)
+	litecoin.InitDatabase(db)
+	dogecoin.InitDatabase(db)
+
+	helper.SetAPIKeys(config.APIKeys)
+	networks := ethereum.Networks(config.EVM)
+	if config.Ethereum.Configured() {
+		networks = append([]ethereum.EthereumConfig{config.Ethereum}, networks...)
+	} else {
+		log.Printf("No ipcpath or endpoint in the ethereum section, /api/ethereum is disabled")
+	}
+	for _, network := range networks {
+		ethereum.StartDepositScanner(network)
+		ethereum.ResumeJobs(network)
+		ethereum.StartTxWatcher(network)
+	}
+
 	router := mux.NewRouter()
 	router.Use(loggingMiddleware)
 
@@ -73,7 +133,13 @@ func main() {
 		litecoin.RegisterHandlers(router.PathPrefix("/api/litecoin").Subrouter(), config.Litecoin)
 		dogecoin.RegisterHandlers(router.PathPrefix("/api/dogecoin").Subrouter(), config.Dogecoin)
 
-		ethereum.RegisterHandlers(router.PathPrefix("/api/ethereum").Subrouter(), config.Ethereum)
+		for _, network := range networks {
+			if network.Name == "" {
+				ethereum.RegisterHandlers(router.PathPrefix("/api/ethereum").Subrouter(), network)
+				continue
+			}
+			ethereum.RegisterHandlers(router.PathPrefix("/api/evm/"+network.Name).Subrouter(), network)
+		}
 
 		bitcoin.InitRedis()
 		litecoin.InitRedis()